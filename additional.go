@@ -0,0 +1,104 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// additionalTarget returns the name whose addresses belong in the additional section when rr is answered,
+// RFC 1035 section 3.3, RFC 2782 and RFC 9460 section 4, or an empty string if there is none.
+func additionalTarget(rr dns.RR) string {
+	var target string
+	switch rr := rr.(type) {
+	case *dns.MX:
+		target = rr.Mx
+	case *dns.SRV:
+		target = rr.Target
+	case *dns.NS:
+		target = rr.Ns
+	case *dns.SVCB:
+		target = svcbTarget(rr.Hdr.Name, rr.Priority, rr.Target)
+	case *dns.HTTPS:
+		target = svcbTarget(rr.Hdr.Name, rr.Priority, rr.Target)
+	}
+	// A target of the root, as a null MX or SRV, means there is no such service
+	if target == rootZone {
+		return ""
+	}
+	return target
+}
+
+// svcbTarget returns the target of a SVCB or HTTPS record, the owner stands for the root target in service mode.
+func svcbTarget(owner string, priority uint16, target string) string {
+	if target == rootZone && priority != zero {
+		return owner
+	}
+	return target
+}
+
+// makeAdditional returns the A/AAAA records of the targets of rrs living in our zones, each target once.
+func (m *Mysql) makeAdditional(ctx context.Context, rrs []dns.RR) ([]dns.RR, error) {
+	var extra []dns.RR
+	seen := make(map[string]bool)
+	for _, rr := range rrs {
+		target := strings.ToLower(additionalTarget(rr))
+		if target == "" || seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		zoneID, host, zone, ok := m.findZone(target)
+		if !ok {
+			continue
+		}
+		for _, addressQtype := range []string{aQtype, aaaaQtype} {
+			records, err := m.getRecords(ctx, zoneID, host, zone, addressQtype)
+			if err != nil {
+				return extra, err
+			}
+			addresses, _ := m.makeAnswers(records)
+			extra = append(extra, addresses...)
+		}
+	}
+	return extra, nil
+}
+
+// trimAdditional drops records from the end of the additional section until msg fits in size once compressed,
+// the additional section is optional so the reply is not truncated for it, RFC 2181 section 9. The OPT record
+// is kept.
+func trimAdditional(msg *dns.Msg, size int) {
+	compress := msg.Compress
+	msg.Compress = true
+	defer func() { msg.Compress = compress }()
+	for i := len(msg.Extra) - 1; i >= zero && msg.Len() > size; i-- {
+		if msg.Extra[i].Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		msg.Extra = append(msg.Extra[:i], msg.Extra[i+1:]...)
+	}
+}
+
+// writeMsg signs and sizes msg for the client of state and writes it. The OPT record of the request is echoed, the
+// additional records that do not fit are dropped, and if the reply still does not fit it is truncated with
+// the TC bit set so the client retries over TCP.
+func (m *Mysql) writeMsg(ctx context.Context, state request.Request, msg *dns.Msg) {
+	// Signed zones are only signed for clients asking for DNSSEC records, RFC 3225
+	if len(m.dnssecKeys) > zero && state.Do() {
+		m.signMsg(ctx, msg)
+	}
+	state.SizeAndDo(msg)
+	trimAdditional(msg, state.Size())
+	msg = state.Scrub(msg)
+	if m.compress {
+		msg.Compress = true
+	}
+	if msg.Truncated {
+		truncatedCount.Inc()
+	}
+	if err := state.W.WriteMsg(msg); err != nil {
+		logger.Error(err)
+	}
+}
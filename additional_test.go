@@ -0,0 +1,172 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdditionalTarget(t *testing.T) {
+	tests := []struct {
+		rr   string
+		want string
+	}{
+		{"example.org. 60 IN MX 10 mail.example.org.", "mail.example.org."},
+		{"_sip._udp.example.org. 60 IN SRV 0 5 5060 sip.example.org.", "sip.example.org."},
+		{"example.org. 60 IN NS ns.example.net.", "ns.example.net."},
+		// A null MX or SRV has no service to look up, RFC 7505 and RFC 2782
+		{"example.org. 60 IN MX 0 .", ""},
+		{"_sip._udp.example.org. 60 IN SRV 0 0 0 .", ""},
+		// The root target of a service mode SVCB stands for its owner
+		{"svc.example.org. 60 IN SVCB 1 . alpn=h2", "svc.example.org."},
+		{"svc.example.org. 60 IN HTTPS 1 web.example.org.", "web.example.org."},
+		{"svc.example.org. 60 IN HTTPS 0 .", ""},
+		{"example.org. 60 IN A 10.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		rr, err := dns.NewRR(tt.rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := additionalTarget(rr); got != tt.want {
+			t.Errorf("target of %q is %q, want %q", tt.rr, got, tt.want)
+		}
+	}
+}
+
+func TestAdditional(t *testing.T) {
+	m := newSnapshotMysql(t, "", map[string]int{"example.org.": 1},
+		record{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+		record{id: 2, zoneID: 1, name: zoneSelf, qType: "MX", data: "10 mail.example.org.", ttl: 60},
+		record{id: 3, zoneID: 1, name: zoneSelf, qType: "MX", data: "20 MAIL.example.org.", ttl: 60},
+		record{id: 4, zoneID: 1, name: zoneSelf, qType: "MX", data: "30 mx.example.net.", ttl: 60},
+		record{id: 5, zoneID: 1, name: "mail", qType: aQtype, data: "10.0.0.1", ttl: 60},
+		record{id: 6, zoneID: 1, name: "mail", qType: aaaaQtype, data: "2001:db8::1", ttl: 60},
+		record{id: 7, zoneID: 1, name: "_sip._udp", qType: "SRV", data: "0 5 5060 sip.example.org.", ttl: 60},
+		record{id: 8, zoneID: 1, name: "sip", qType: aQtype, data: "10.0.0.2", ttl: 60},
+	)
+
+	tests := []struct {
+		name  string
+		qType uint16
+		extra []string
+	}{
+		// Each target in our zones once, whatever its case, and none for the one outside
+		{"example.org.", dns.TypeMX, []string{
+			"mail.example.org.\t60\tIN\tA\t10.0.0.1",
+			"mail.example.org.\t60\tIN\tAAAA\t2001:db8::1",
+		}},
+		{"_sip._udp.example.org.", dns.TypeSRV, []string{"sip.example.org.\t60\tIN\tA\t10.0.0.2"}},
+		{"mail.example.org.", dns.TypeA, nil},
+	}
+	for _, tt := range tests {
+		msg := exchange(t, m, tt.name, tt.qType)
+		if got := rrStrings(msg.Extra); !reflect.DeepEqual(got, tt.extra) {
+			t.Errorf("%s %s: additional %q, want %q", tt.name, dns.TypeToString[tt.qType], got, tt.extra)
+		}
+	}
+}
+
+// exchangeSize serves a query of name and qType over udp with an EDNS0 buffer of size, or without EDNS0 if size
+// is zero, or over tcp.
+func exchangeSize(t *testing.T, m *Mysql, name string, qType uint16, size uint16, tcp bool) *dns.Msg {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(name, qType)
+	if size > zero {
+		r.SetEdns0(size, false)
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tcp})
+	if _, err := m.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Msg
+}
+
+func TestTrimAdditional(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.org.", dns.TypeMX)
+	for i := 0; i < 20; i++ {
+		mx, _ := dns.NewRR(fmt.Sprintf("example.org. 60 IN MX 10 mx%d.example.org.", i))
+		a, _ := dns.NewRR(fmt.Sprintf("mx%d.example.org. 60 IN A 10.0.0.%d", i, i))
+		msg.Answer = append(msg.Answer, mx)
+		msg.Extra = append(msg.Extra, a)
+	}
+	msg.SetEdns0(512, false)
+
+	trimAdditional(msg, 512)
+	if msg.Compress {
+		t.Error("trim left the message compressed")
+	}
+	msg.Compress = true
+	if msg.Len() > 512 {
+		t.Errorf("trimmed message is %d octets, want at most 512", msg.Len())
+	}
+	if len(msg.Answer) != 20 || len(msg.Extra) < 2 || len(msg.Extra) > 20 {
+		t.Fatalf("trimmed message has %d answers and %d additional records", len(msg.Answer), len(msg.Extra))
+	}
+	if msg.IsEdns0() == nil {
+		t.Error("trim dropped the OPT record")
+	}
+	// Records are dropped from the end
+	if a, ok := msg.Extra[zero].(*dns.A); !ok || a.Hdr.Name != "mx0.example.org." {
+		t.Errorf("trim kept %q first", msg.Extra[zero])
+	}
+}
+
+func TestWriteMsgSize(t *testing.T) {
+	records := []record{
+		{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+	}
+	for i := 0; i < 40; i++ {
+		records = append(records, record{id: 100 + i, zoneID: 1, name: "many", qType: aQtype, data: fmt.Sprintf("10.0.0.%d", i), ttl: 60})
+	}
+	for i := 0; i < 20; i++ {
+		records = append(records,
+			record{id: 200 + i, zoneID: 1, name: zoneSelf, qType: "MX", data: fmt.Sprintf("10 mx%d.example.org.", i), ttl: 60},
+			record{id: 300 + i, zoneID: 1, name: fmt.Sprintf("mx%d", i), qType: aQtype, data: fmt.Sprintf("10.0.1.%d", i), ttl: 60},
+		)
+	}
+	m := newSnapshotMysql(t, "", map[string]int{"example.org.": 1}, records...)
+
+	// Without EDNS0 the reply is not given one, and an answer over 512 octets is truncated
+	truncated := testutil.ToFloat64(truncatedCount)
+	msg := exchangeSize(t, m, "many.example.org.", dns.TypeA, 0, false)
+	if !msg.Truncated || msg.IsEdns0() != nil || len(msg.Answer) >= 40 {
+		t.Errorf("udp reply without EDNS0 got TC %t, OPT %t and %d answers", msg.Truncated, msg.IsEdns0() != nil, len(msg.Answer))
+	}
+	if got := testutil.ToFloat64(truncatedCount) - truncated; got != 1 {
+		t.Errorf("truncated count grew by %v, want 1", got)
+	}
+
+	// The OPT of the request is echoed and its buffer size honoured
+	msg = exchangeSize(t, m, "many.example.org.", dns.TypeA, 4096, false)
+	if msg.Truncated || len(msg.Answer) != 40 {
+		t.Errorf("udp reply with a 4096 octets buffer got TC %t and %d answers, want 40", msg.Truncated, len(msg.Answer))
+	}
+	if opt := msg.IsEdns0(); opt == nil || opt.Do() {
+		t.Errorf("udp reply with EDNS0 got OPT %v, want one without DO", opt)
+	}
+	if msg = exchangeSize(t, m, "many.example.org.", dns.TypeA, 0, true); msg.Truncated || len(msg.Answer) != 40 {
+		t.Errorf("tcp reply got TC %t and %d answers, want 40", msg.Truncated, len(msg.Answer))
+	}
+
+	// Additional records that do not fit are dropped instead of truncating the answer
+	msg = exchangeSize(t, m, "example.org.", dns.TypeMX, 512, false)
+	msg.Compress = true
+	if msg.Truncated || len(msg.Answer) != 20 || len(msg.Extra) >= 21 || msg.Len() > 512 {
+		t.Errorf("udp MX reply got TC %t, %d answers, %d additional records and %d octets", msg.Truncated, len(msg.Answer), len(msg.Extra), msg.Len())
+	}
+	if msg.IsEdns0() == nil {
+		t.Error("trimmed MX reply lost its OPT record")
+	}
+	if msg = exchangeSize(t, m, "example.org.", dns.TypeMX, 4096, false); len(msg.Extra) != 21 {
+		t.Errorf("udp MX reply with a 4096 octets buffer got %d additional records, want 20 and the OPT", len(msg.Extra))
+	}
+}
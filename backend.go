@@ -0,0 +1,302 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errBackendNotReady = errors.New("backend not ready")
+
+// Backend is the storage of zones and records the plugin answers from.
+type Backend interface {
+	// ListZones returns the id of every zone by zone name, and the default ttl of the zones having one by zone id.
+	ListZones(ctx context.Context) (map[string]int, map[int]uint32, error)
+	// LookupRecords returns the online records of host in zone with type qType, or of every type if qType is empty.
+	LookupRecords(ctx context.Context, zoneID int, zone, host, qType string) ([]record, error)
+	// NameExists reports whether host owns online records or has descendants owning some.
+	NameExists(ctx context.Context, zoneID int, host string) (bool, error)
+	// ListRecords returns every online record, the zone name of the records is not filled.
+	ListRecords(ctx context.Context) ([]record, error)
+	// ListZoneRecords returns every online record of zone.
+	ListZoneRecords(ctx context.Context, zoneID int, zone string) ([]record, error)
+	// UpdateRecordData sets the data of record id.
+	UpdateRecordData(ctx context.Context, id int, data string) error
+	// Ping checks the health of the backend.
+	Ping(ctx context.Context) error
+	// CreateSchema creates the zones and records tables if they do not exist.
+	CreateSchema(ctx context.Context)
+	// Close releases the connections of the backend.
+	Close() error
+}
+
+// sqlBackend implements Backend on top of database/sql, the dialect specific parts are left to the drivers.
+type sqlBackend struct {
+	*mysqlConfig
+
+	dsn string
+	db  *sql.DB
+	// rebind rewrites the ? placeholders of a query for the driver
+	rebind func(query string) string
+}
+
+func (m *Mysql) openBackend(dsn string) (Backend, error) {
+	switch m.driver {
+	case postgresDriver:
+		return newPostgresBackend(dsn, m.mysqlConfig)
+	case sqliteDriver:
+		return newSqliteBackend(dsn, m.mysqlConfig)
+	default:
+		return newMysqlBackend(dsn, m.mysqlConfig)
+	}
+}
+
+func (m *Mysql) getBackend() (Backend, error) {
+	m.backendLock.RLock()
+	defer m.backendLock.RUnlock()
+	if m.backend == nil {
+		return nil, errBackendNotReady
+	}
+	return m.backend, nil
+}
+
+func openSQLBackend(driverName, dsn string, config *mysqlConfig, rebind func(string) string) (*sqlBackend, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		openMysqlCount.With(prometheus.Labels{"status": "fail"}).Inc()
+		logger.Errorf("Failed to open database %s: %s", endpointName(dsn), err)
+		return nil, err
+	}
+	// Config db connection pool
+	db.SetConnMaxIdleTime(config.connMaxIdleTime)
+	db.SetConnMaxLifetime(config.connMaxLifetime)
+	db.SetMaxIdleConns(config.maxIdleConns)
+	db.SetMaxOpenConns(config.maxOpenConns)
+	openMysqlCount.With(prometheus.Labels{"status": "success"}).Inc()
+	logger.Debugf("Success to open %s database %s", driverName, endpointName(dsn))
+
+	if rebind == nil {
+		rebind = func(query string) string { return query }
+	}
+	return &sqlBackend{mysqlConfig: config, dsn: dsn, db: db, rebind: rebind}, nil
+}
+
+func (b *sqlBackend) ListZones(ctx context.Context) (map[string]int, map[int]uint32, error) {
+	zoneMap := make(map[string]int, 0)
+	zoneTTLs := make(map[int]uint32, 0)
+	rows, err := b.db.QueryContext(ctx, b.rebind(b.queryZoneSQL))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for rows.Next() {
+		var (
+			zoneRecord zoneRecord
+			ttl        sql.NullInt64
+		)
+		// The third column is the default ttl of the zone, if zone_ttl_column is set
+		if len(columns) > 2 {
+			err = rows.Scan(&zoneRecord.id, &zoneRecord.name, &ttl)
+		} else {
+			err = rows.Scan(&zoneRecord.id, &zoneRecord.name)
+		}
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		zoneMap[zoneRecord.name] = zoneRecord.id
+		if zoneTTL := rowTTL(ttl); zoneTTL > zero {
+			zoneTTLs[zoneRecord.id] = zoneTTL
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return zoneMap, zoneTTLs, nil
+}
+
+func (b *sqlBackend) LookupRecords(ctx context.Context, zoneID int, zone, host, qType string) ([]record, error) {
+	if qType == "" {
+		return b.queryRecords(ctx, zone, b.queryHostSQL, zoneID, host)
+	}
+	return b.queryRecords(ctx, zone, b.queryRecordSQL, zoneID, host, qType)
+}
+
+func (b *sqlBackend) NameExists(ctx context.Context, zoneID int, host string) (bool, error) {
+	var exists int
+	err := b.db.QueryRowContext(ctx, b.rebind(b.queryNameSQL), zoneID, host, "%"+zoneSeparator+escapeLike(host)).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *sqlBackend) ListRecords(ctx context.Context) ([]record, error) {
+	return b.queryRecords(ctx, "", b.queryAllRecordSQL)
+}
+
+func (b *sqlBackend) ListZoneRecords(ctx context.Context, zoneID int, zone string) ([]record, error) {
+	return b.queryRecords(ctx, zone, b.queryZoneRecordSQL, zoneID)
+}
+
+func (b *sqlBackend) UpdateRecordData(ctx context.Context, id int, data string) error {
+	_, err := b.db.ExecContext(ctx, b.rebind(b.updateRecordSQL), data, id)
+	return err
+}
+
+func (b *sqlBackend) queryRecords(ctx context.Context, zone, query string, args ...interface{}) ([]record, error) {
+	var records []record
+
+	rows, err := b.db.QueryContext(ctx, b.rebind(query), args...)
+	if err != nil {
+		logger.Errorf("Query record error: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			record record
+			ttl    sql.NullInt64
+		)
+		err := rows.Scan(&record.id, &record.zoneID, &record.name, &record.qType, &record.data, &ttl)
+		record.ttl = rowTTL(ttl)
+		if err != nil {
+			queryDBCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			logger.Debugf("Failed to get records for domain %s from database: %s", record.fqdn, err)
+			return nil, err
+		}
+		if zone != "" {
+			record.setZone(zone)
+		}
+		records = append(records, record)
+	}
+	queryDBCount.With(prometheus.Labels{"status": "success"}).Inc()
+	return records, rows.Err()
+}
+
+func (b *sqlBackend) Ping(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
+func (b *sqlBackend) Close() error {
+	return b.db.Close()
+}
+
+// ChangeMark returns the current high-water mark of the change column or change table.
+func (b *sqlBackend) ChangeMark(ctx context.Context) (interface{}, error) {
+	var changeMark interface{}
+	if err := b.db.QueryRowContext(ctx, b.rebind(b.queryChangeMarkSQL)).Scan(&changeMark); err != nil {
+		return nil, err
+	}
+	// Empty records table, start from the very beginning
+	if changeMark == nil {
+		changeMark = zeroChangeMark
+	}
+	return changeMark, nil
+}
+
+// Changes returns the rows changed since changeMark and the new high-water mark.
+func (b *sqlBackend) Changes(ctx context.Context, changeMark interface{}) ([]recordChange, interface{}, error) {
+	var changes []recordChange
+
+	rows, err := b.db.QueryContext(ctx, b.rebind(b.queryChangeSQL), changeMark)
+	if err != nil {
+		return nil, changeMark, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id, zoneID, ttl, online sql.NullInt64
+			name, qType, data       sql.NullString
+			nextMark                interface{}
+		)
+		err := rows.Scan(&id, &zoneID, &name, &qType, &data, &ttl, &online, &nextMark)
+		if err != nil {
+			return nil, changeMark, err
+		}
+		changeMark = nextMark
+		// A deleted row of a change table has no record columns, it is removed like an offline one
+		change := recordChange{
+			record: record{
+				id:     int(id.Int64),
+				zoneID: int(zoneID.Int64),
+				name:   name.String,
+				qType:  qType.String,
+				data:   data.String,
+				ttl:    rowTTL(ttl),
+			},
+			online: zoneID.Valid && online.Int64 != zero,
+		}
+		changes = append(changes, change)
+	}
+	return changes, changeMark, rows.Err()
+}
+
+// rowTTL converts the ttl column of a row, NULL or non positive ttls are 0 so the default ttl applies.
+func rowTTL(ttl sql.NullInt64) uint32 {
+	switch {
+	case !ttl.Valid || ttl.Int64 <= zero:
+		return zero
+	case ttl.Int64 > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(ttl.Int64)
+}
+
+// createTable runs the create table statement of table and counts the result.
+func (b *sqlBackend) createTable(ctx context.Context, table, statement string) {
+	_, err := b.db.ExecContext(ctx, statement)
+	if err != nil {
+		createTableCount.With(prometheus.Labels{"status": "fail", "table_name": table}).Inc()
+		logger.Error(err)
+	} else {
+		createTableCount.With(prometheus.Labels{"status": "success", "table_name": table}).Inc()
+	}
+}
+
+// escapeLike escapes the LIKE wildcards of value with the ! escape character used by the name query.
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+// rebindDollar rewrites the ? placeholders of query to $1, $2 ... outside of quoted strings.
+func rebindDollar(query string) string {
+	var (
+		builder strings.Builder
+		quote   rune
+		index   int
+	)
+	for _, char := range query {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case char == '?':
+			index++
+			builder.WriteString("$" + strconv.Itoa(index))
+			continue
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
+
+func unknownDriverError(driver string) error {
+	return fmt.Errorf("unknown driver '%s', must be one of %s, %s, %s", driver, mysqlDriver, postgresDriver, sqliteDriver)
+}
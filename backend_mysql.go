@@ -0,0 +1,107 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// mysqlBackend is the MySQL Backend, it also serves the binlog subscriber.
+type mysqlBackend struct {
+	*sqlBackend
+}
+
+func newMysqlBackend(dsn string, config *mysqlConfig) (*mysqlBackend, error) {
+	backend, err := openSQLBackend(mysqlDriver, dsn, config, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlBackend{sqlBackend: backend}, nil
+}
+
+func (b *mysqlBackend) CreateSchema(ctx context.Context) {
+	b.createTable(ctx, b.zonesTable, `
+        CREATE TABLE IF NOT EXISTS `+b.zonesTable+` (
+            id INT NOT NULL AUTO_INCREMENT,
+            zone_name VARCHAR(255) NOT NULL,
+            PRIMARY KEY (id),
+            UNIQUE KEY (zone_name)
+        );
+    `)
+
+	b.createTable(ctx, b.recordsTable, `
+        CREATE TABLE IF NOT EXISTS `+b.recordsTable+` (
+            id INT NOT NULL AUTO_INCREMENT,
+            zone_id INT NOT NULL,
+            hostname VARCHAR(512) NOT NULL,
+            type VARCHAR(10) NOT NULL,
+            data VARCHAR(1024) NOT NULL,
+            ttl INT NOT NULL DEFAULT 120,
+			online INT NOT NULL DEFAULT 0,
+            PRIMARY KEY (id),
+            FOREIGN KEY (zone_id) REFERENCES `+b.zonesTable+`(id)
+        );
+    `)
+}
+
+// MasterPosition returns the current binlog position of the server.
+func (b *mysqlBackend) MasterPosition(ctx context.Context) (binlogPosition, error) {
+	var position binlogPosition
+
+	rows, err := b.db.QueryContext(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		return position, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return position, err
+	}
+	if !rows.Next() {
+		return position, errors.New("binary log is not enabled")
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return position, err
+	}
+	pos, err := strconv.ParseUint(string(values[1]), 10, 32)
+	if err != nil {
+		return position, err
+	}
+	position.Name, position.Pos = string(values[0]), uint32(pos)
+	return position, nil
+}
+
+// Columns returns the position of every column of table, binlog row events carry values by position only.
+func (b *mysqlBackend) Columns(ctx context.Context, schema, table string) (map[string]int, error) {
+	columns := make(map[string]int)
+
+	rows, err := b.db.QueryContext(ctx, "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=? AND TABLE_NAME=? ORDER BY ORDINAL_POSITION", schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = len(columns)
+	}
+	for _, name := range []string{"id", "zone_id", "hostname", "type", "data", "ttl", "online"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("column %s not exist in table %s.%s", name, schema, table)
+		}
+	}
+	return columns, rows.Err()
+}
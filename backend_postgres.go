@@ -0,0 +1,41 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresBackend is the PostgreSQL Backend.
+type postgresBackend struct {
+	*sqlBackend
+}
+
+func newPostgresBackend(dsn string, config *mysqlConfig) (*postgresBackend, error) {
+	backend, err := openSQLBackend(postgresDriver, dsn, config, rebindDollar)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresBackend{sqlBackend: backend}, nil
+}
+
+func (b *postgresBackend) CreateSchema(ctx context.Context) {
+	b.createTable(ctx, b.zonesTable, `
+        CREATE TABLE IF NOT EXISTS `+b.zonesTable+` (
+            id SERIAL PRIMARY KEY,
+            zone_name VARCHAR(255) NOT NULL UNIQUE
+        );
+    `)
+
+	b.createTable(ctx, b.recordsTable, `
+        CREATE TABLE IF NOT EXISTS `+b.recordsTable+` (
+            id SERIAL PRIMARY KEY,
+            zone_id INT NOT NULL REFERENCES `+b.zonesTable+`(id),
+            hostname VARCHAR(512) NOT NULL,
+            type VARCHAR(10) NOT NULL,
+            data VARCHAR(1024) NOT NULL,
+            ttl INT NOT NULL DEFAULT 120,
+            online INT NOT NULL DEFAULT 0
+        );
+    `)
+}
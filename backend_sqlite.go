@@ -0,0 +1,41 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBackend is the SQLite Backend, it needs no database server at all.
+type sqliteBackend struct {
+	*sqlBackend
+}
+
+func newSqliteBackend(dsn string, config *mysqlConfig) (*sqliteBackend, error) {
+	backend, err := openSQLBackend(sqliteDriver, dsn, config, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteBackend{sqlBackend: backend}, nil
+}
+
+func (b *sqliteBackend) CreateSchema(ctx context.Context) {
+	b.createTable(ctx, b.zonesTable, `
+        CREATE TABLE IF NOT EXISTS `+b.zonesTable+` (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            zone_name VARCHAR(255) NOT NULL UNIQUE
+        );
+    `)
+
+	b.createTable(ctx, b.recordsTable, `
+        CREATE TABLE IF NOT EXISTS `+b.recordsTable+` (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            zone_id INTEGER NOT NULL REFERENCES `+b.zonesTable+`(id),
+            hostname VARCHAR(512) NOT NULL,
+            type VARCHAR(10) NOT NULL,
+            data VARCHAR(1024) NOT NULL,
+            ttl INTEGER NOT NULL DEFAULT 120,
+            online INTEGER NOT NULL DEFAULT 0
+        );
+    `)
+}
@@ -0,0 +1,66 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestListZones checks a zone row which can not be scanned is skipped instead of listed as an empty zone.
+func TestListZones(t *testing.T) {
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s\nzone_ttl_column default_ttl", filepath.Join(t.TempDir(), "dns.db")))
+	m.openEndpoints()
+	t.Cleanup(m.closeEndpoints)
+	backend, _ := m.getBackend()
+	backend.CreateSchema(context.Background())
+	for _, statement := range []string{
+		"ALTER TABLE zones ADD COLUMN default_ttl TEXT",
+		"INSERT INTO zones(id, zone_name, default_ttl) VALUES(1, 'example.org.', '120'), (2, 'example.net.', 'bad'), (3, 'example.com.', NULL)",
+	} {
+		if _, err := backend.(*sqliteBackend).db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zoneMap, zoneTTLs, err := backend.ListZones(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"example.org.": 1, "example.com.": 3}; !reflect.DeepEqual(zoneMap, want) {
+		t.Errorf("got zones %v, want %v", zoneMap, want)
+	}
+	if want := map[int]uint32{1: 120}; !reflect.DeepEqual(zoneTTLs, want) {
+		t.Errorf("got zone ttls %v, want %v", zoneTTLs, want)
+	}
+}
+
+// TestNameExists checks the empty non-terminals are found by the database like by the snapshot.
+func TestNameExists(t *testing.T) {
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s", filepath.Join(t.TempDir(), "dns.db")))
+	m.openEndpoints()
+	t.Cleanup(m.closeEndpoints)
+	backend, _ := m.getBackend()
+	backend.CreateSchema(context.Background())
+	statement := "INSERT INTO records(id, zone_id, hostname, type, data, ttl, online) VALUES" +
+		"(1, 1, 'host.sub', 'A', '10.0.0.1', 60, 1), (2, 1, 'x.aab', 'A', '10.0.0.2', 60, 1), (3, 1, 'off.line', 'A', '10.0.0.3', 60, 0)"
+	if _, err := backend.(*sqliteBackend).db.Exec(statement); err != nil {
+		t.Fatal(err)
+	}
+
+	for host, want := range map[string]bool{
+		"host.sub": true,
+		"sub":      true,
+		"ub":       false,
+		"aab":      true,
+		// LIKE wildcards of the name are escaped
+		"%":    false,
+		"a_b":  false,
+		"line": false,
+	} {
+		if got, err := backend.NameExists(context.Background(), 1, host); err != nil || got != want {
+			t.Errorf("NameExists(%q) = %v, %v, want %v", host, got, err, want)
+		}
+	}
+}
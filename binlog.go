@@ -0,0 +1,308 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/siddontang/go-log/log"
+)
+
+var errZonesChanged = errors.New("zones table changed")
+
+// binlogPosition is the position of the last applied transaction, persisted next to the dump file.
+type binlogPosition struct {
+	Name string `json:"name"`
+	Pos  uint32 `json:"pos"`
+}
+
+func (m *Mysql) binlogPositionFile() string {
+	return m.dumpFile + binlogPositionSuffix
+}
+
+func (m *Mysql) loadBinlogPosition() (binlogPosition, bool) {
+	var position binlogPosition
+	content, err := os.ReadFile(m.binlogPositionFile())
+	if err != nil {
+		return position, false
+	}
+	if err := json.Unmarshal(content, &position); err != nil || position.Name == "" {
+		logger.Warningf("Ignore invalid binlog position file %s", m.binlogPositionFile())
+		return position, false
+	}
+	return position, true
+}
+
+func (m *Mysql) saveBinlogPosition(position binlogPosition) {
+	content, err := json.Marshal(position)
+	if err != nil {
+		logger.Errorf("Failed to save binlog position: %s", err)
+		return
+	}
+	// Written like the dump file, a crash never leaves a truncated position behind
+	path := m.binlogPositionFile()
+	temp := path + dumpTempSuffix
+	if err := writeSyncFile(temp, content); err != nil {
+		os.Remove(temp)
+		logger.Errorf("Failed to save binlog position: %s", err)
+		return
+	}
+	if err := os.Rename(temp, path); err != nil {
+		logger.Errorf("Failed to save binlog position: %s", err)
+		return
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		logger.Errorf("Failed to save binlog position: %s", err)
+	}
+}
+
+// uniqueBinlogServerID derives a replication server id from the hostname and the pid, randomized so the
+// instances of a fleet do not kick each other off the server by connecting with the same id.
+func uniqueBinlogServerID() uint32 {
+	hostname, _ := os.Hostname()
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%s/%d/%d", hostname, os.Getpid(), rand.Int63())
+	return binlogServerIDBase + hash.Sum32()%(math.MaxUint32-binlogServerIDBase)
+}
+
+// followBinlog streams row events of the zones and records tables from position and applies them to snapshot.
+// It returns nil when a full reload is needed, because the position is lost or the zones changed, and an error
+// when the binlog can not be followed at all.
+func (m *Mysql) followBinlog(snapshot *zoneSnapshot, position binlogPosition) error {
+	backend, err := m.getBackend()
+	if err != nil {
+		return err
+	}
+	mysqlBackend, ok := backend.(*mysqlBackend)
+	if !ok {
+		return fmt.Errorf("binlog needs the %s backend", mysqlDriver)
+	}
+	dsnConfig, err := mysqldriver.ParseDSN(mysqlBackend.dsn)
+	if err != nil {
+		return fmt.Errorf("failed to parse dsn: %w", err)
+	}
+	host, portString, err := net.SplitHostPort(dsnConfig.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to parse dsn address: %w", err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("failed to parse dsn port: %w", err)
+	}
+	columns, err := mysqlBackend.Columns(m.ctx, dsnConfig.DBName, m.recordsTable)
+	if err != nil {
+		return fmt.Errorf("failed to query columns of %s: %w", m.recordsTable, err)
+	}
+	nullHandler, _ := log.NewNullHandler()
+
+	for {
+		syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+			ServerID: m.binlogServerID,
+			Flavor:   gomysql.MySQLFlavor,
+			Host:     host,
+			Port:     uint16(port),
+			User:     dsnConfig.User,
+			Password: dsnConfig.Passwd,
+			Logger:   log.NewDefault(nullHandler),
+		})
+		streamer, err := syncer.StartSync(gomysql.Position{Name: position.Name, Pos: position.Pos})
+		if err == nil {
+			logger.Infof("Start binlog sync from %s:%d", position.Name, position.Pos)
+			binlogSyncCount.With(prometheus.Labels{"status": "success"}).Inc()
+			snapshot, position, err = m.streamBinlog(streamer, snapshot, position, dsnConfig.DBName, columns)
+		}
+		syncer.Close()
+
+		var myErr *gomysql.MyError
+		if errors.As(err, &myErr) && myErr.Code == gomysql.ER_MASTER_FATAL_ERROR_READING_BINLOG {
+			logger.Errorf("Binlog position %s:%d lost, reload zone snapshot: %s", position.Name, position.Pos, err)
+			binlogSyncCount.With(prometheus.Labels{"status": "lost"}).Inc()
+			return nil
+		}
+		if errors.Is(err, errZonesChanged) {
+			logger.Infof("Zones changed, reload zone snapshot")
+			return nil
+		}
+		logger.Errorf("Failed to sync binlog: %s", err)
+		binlogSyncCount.With(prometheus.Labels{"status": "fail"}).Inc()
+
+		if !m.sleep(m.failHeartbeatTime) {
+			return nil
+		}
+	}
+}
+
+// streamBinlog applies committed transactions to snapshot until the stream fails.
+// It returns the latest snapshot and the position of the last applied transaction.
+// Committed transactions are applied at once when the stream is quiet, and at most every binlogFlushTime when
+// it is busy, so a stream of events of other tables never holds them back.
+func (m *Mysql) streamBinlog(streamer *replication.BinlogStreamer, snapshot *zoneSnapshot, position binlogPosition,
+	schema string, columns map[string]int) (*zoneSnapshot, binlogPosition, error) {
+	var (
+		current   = position
+		saved     = position
+		lastFlush time.Time
+		pending   []recordChange
+		committed []recordChange
+	)
+
+	flush := func() {
+		if changes := snapshot.pending(committed, m.soaSerial != ""); len(changes) > zero {
+			snapshot = snapshot.apply(changes)
+			m.swapSnapshot(snapshot)
+			logger.Debugf("Success to apply %d binlog record changes", len(changes))
+			changeApplyCount.With(prometheus.Labels{"status": "success"}).Inc()
+		}
+		committed = nil
+		if position != saved {
+			m.saveBinlogPosition(position)
+			saved = position
+		}
+		lastFlush = time.Now()
+	}
+
+	for {
+		if (len(committed) > zero || position != saved) && time.Since(lastFlush) >= binlogFlushTime {
+			flush()
+		}
+		ctx, cancel := context.WithTimeout(m.ctx, binlogFlushTime)
+		event, err := streamer.GetEvent(ctx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			flush()
+			continue
+		}
+		if err != nil {
+			flush()
+			return snapshot, position, err
+		}
+
+		switch e := event.Event.(type) {
+		case *replication.RotateEvent:
+			current = binlogPosition{Name: string(e.NextLogName), Pos: uint32(e.Position)}
+			continue
+		case *replication.RowsEvent:
+			if string(e.Table.Schema) != schema {
+				break
+			}
+			switch string(e.Table.Table) {
+			case m.zonesTable:
+				flush()
+				return snapshot, position, errZonesChanged
+			case m.recordsTable:
+				pending = append(pending, binlogRecordChanges(event.Header.EventType, e.Rows, columns)...)
+			}
+		case *replication.XIDEvent:
+			current.Pos = event.Header.LogPos
+			position = current
+			committed = append(committed, pending...)
+			pending = nil
+			continue
+		}
+		if event.Header.LogPos > zero {
+			current.Pos = event.Header.LogPos
+		}
+	}
+}
+
+// binlogRecordChanges converts the rows of a row event of the records table.
+func binlogRecordChanges(eventType replication.EventType, rows [][]interface{}, columns map[string]int) []recordChange {
+	var changes []recordChange
+
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		for _, row := range rows {
+			changes = append(changes, binlogRecordChange(row, columns, false))
+		}
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		// Rows of an update event are pairs of before and after images
+		for i := 0; i+1 < len(rows); i += 2 {
+			before, after := binlogRecordChange(rows[i], columns, true), binlogRecordChange(rows[i+1], columns, false)
+			if before.record.id != after.record.id {
+				changes = append(changes, before)
+			}
+			changes = append(changes, after)
+		}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range rows {
+			changes = append(changes, binlogRecordChange(row, columns, true))
+		}
+	}
+	return changes
+}
+
+func binlogRecordChange(row []interface{}, columns map[string]int, removed bool) recordChange {
+	value := func(name string) interface{} {
+		if i := columns[name]; i < len(row) {
+			return row[i]
+		}
+		return nil
+	}
+	return recordChange{
+		record: record{
+			id:     int(binlogInt(value("id"))),
+			zoneID: int(binlogInt(value("zone_id"))),
+			name:   binlogString(value("hostname")),
+			qType:  binlogString(value("type")),
+			data:   binlogString(value("data")),
+			ttl:    rowTTL(sql.NullInt64{Int64: binlogInt(value("ttl")), Valid: value("ttl") != nil}),
+		},
+		online: !removed && binlogInt(value("online")) != zero,
+	}
+}
+
+func binlogInt(value interface{}) int64 {
+	switch v := value.(type) {
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	case []byte:
+		i, _ := strconv.ParseInt(string(v), 10, 64)
+		return i
+	}
+	return zero
+}
+
+func binlogString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
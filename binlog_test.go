@@ -0,0 +1,214 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// newTestMysql returns a plugin configured by the body of a mysql block, with the state set up by setup but
+// without any open database.
+func newTestMysql(t *testing.T, body string) *Mysql {
+	t.Helper()
+	c := caddy.NewTestController("dns", "mysql {\n"+body+"\n}")
+	m := MakeMysqlPlugin()
+	if err := m.parseConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	m.dumpFile = filepath.Join(t.TempDir(), "dump.json")
+	m.initEndpoints()
+	m.formatSQL()
+	m.degradeCache = newRecordCache(m.degradeMaxEntries, m.degradeMaxAge)
+	m.signatures = newSignatureCache()
+	m.journal = newZoneJournal()
+	m.serials = newZoneSerials()
+	m.notifier = newNotifier()
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	t.Cleanup(m.cancel)
+	return m
+}
+
+// binlogColumns is the layout of the default records table.
+var binlogColumns = map[string]int{"id": 0, "zone_id": 1, "hostname": 2, "type": 3, "data": 4, "ttl": 5, "online": 6}
+
+func binlogRowsEvent(eventType replication.EventType, schema, table string, rows ...[]interface{}) *replication.BinlogEvent {
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: eventType},
+		Event: &replication.RowsEvent{
+			Table: &replication.TableMapEvent{Schema: []byte(schema), Table: []byte(table)},
+			Rows:  rows,
+		},
+	}
+}
+
+func binlogXIDEvent(pos uint32) *replication.BinlogEvent {
+	return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.XID_EVENT, LogPos: pos}, Event: &replication.XIDEvent{}}
+}
+
+func TestBinlogRecordChanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType replication.EventType
+		rows      [][]interface{}
+		want      []recordChange
+	}{
+		{
+			name:      "insert",
+			eventType: replication.WRITE_ROWS_EVENTv2,
+			rows:      [][]interface{}{{int32(1), int32(2), "www", "A", "10.0.0.1", int32(60), int8(1)}},
+			want:      []recordChange{{record: record{id: 1, zoneID: 2, name: "www", qType: "A", data: "10.0.0.1", ttl: 60}, online: true}},
+		},
+		{
+			name:      "insert offline with null ttl",
+			eventType: replication.WRITE_ROWS_EVENTv1,
+			rows:      [][]interface{}{{int32(1), int32(2), []byte("www"), []byte("A"), []byte("10.0.0.1"), nil, int8(0)}},
+			want:      []recordChange{{record: record{id: 1, zoneID: 2, name: "www", qType: "A", data: "10.0.0.1"}}},
+		},
+		{
+			name:      "update",
+			eventType: replication.UPDATE_ROWS_EVENTv2,
+			rows: [][]interface{}{
+				{int32(1), int32(2), "www", "A", "10.0.0.1", int32(60), int8(1)},
+				{int32(1), int32(2), "www", "A", "10.0.0.2", int32(60), int8(1)},
+			},
+			want: []recordChange{{record: record{id: 1, zoneID: 2, name: "www", qType: "A", data: "10.0.0.2", ttl: 60}, online: true}},
+		},
+		{
+			name:      "update of the id",
+			eventType: replication.UPDATE_ROWS_EVENTv2,
+			rows: [][]interface{}{
+				{int32(1), int32(2), "www", "A", "10.0.0.1", int32(60), int8(1)},
+				{int32(3), int32(2), "www", "A", "10.0.0.1", int32(60), int8(1)},
+			},
+			want: []recordChange{
+				{record: record{id: 1, zoneID: 2, name: "www", qType: "A", data: "10.0.0.1", ttl: 60}},
+				{record: record{id: 3, zoneID: 2, name: "www", qType: "A", data: "10.0.0.1", ttl: 60}, online: true},
+			},
+		},
+		{
+			name:      "delete",
+			eventType: replication.DELETE_ROWS_EVENTv2,
+			rows:      [][]interface{}{{int64(1), int64(2), "www", "A", "10.0.0.1", int64(60), int64(1)}},
+			want:      []recordChange{{record: record{id: 1, zoneID: 2, name: "www", qType: "A", data: "10.0.0.1", ttl: 60}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := binlogRecordChanges(test.eventType, test.rows, binlogColumns)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestStreamBinlogBusy checks committed record changes are applied while events of other tables keep coming.
+func TestStreamBinlogBusy(t *testing.T) {
+	m := newTestMysql(t, "binlog")
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	m.swapSnapshot(snapshot)
+
+	streamer := replication.NewBinlogStreamer()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Millisecond * 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				streamer.AddErrorToStreamer(errors.New("stop"))
+				return
+			case <-ticker.C:
+				streamer.AddEventToStreamer(binlogRowsEvent(replication.WRITE_ROWS_EVENTv2, "other", "logs", []interface{}{int32(1)}))
+			}
+		}
+	}()
+	streamer.AddEventToStreamer(binlogRowsEvent(replication.WRITE_ROWS_EVENTv2, "dns", m.recordsTable,
+		[]interface{}{int32(1), int32(1), "www", "A", "10.0.0.1", int32(60), int8(1)}))
+	streamer.AddEventToStreamer(binlogXIDEvent(120))
+
+	result := make(chan binlogPosition, 1)
+	go func() {
+		_, position, _ := m.streamBinlog(streamer, snapshot, binlogPosition{Name: "binlog.000001", Pos: 4}, "dns", binlogColumns)
+		result <- position
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(m.snapshot.Load().getRecords(1, "www", "A")) == zero {
+		if time.Now().After(deadline) {
+			t.Fatal("record change not applied while the binlog is busy")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if position, ok := m.loadBinlogPosition(); !ok || position.Pos != 120 {
+		t.Errorf("saved binlog position %+v, want pos 120", position)
+	}
+	close(stop)
+	<-done
+	if position := <-result; position.Pos != 120 {
+		t.Errorf("binlog position %+v, want pos 120", position)
+	}
+}
+
+func TestBinlogConfig(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr bool
+	}{
+		{"binlog\ndsn username:password@tcp(127.0.0.1:3306)/dns", false},
+		{"binlog\ndsn username:password@unix(/run/mysqld/mysqld.sock)/dns", true},
+		{"binlog\ndriver sqlite\ndsn file:dns.db", true},
+	}
+	for _, test := range tests {
+		c := caddy.NewTestController("dns", "mysql {\n"+test.body+"\n}")
+		if err := MakeMysqlPlugin().parseConfig(c); (err != nil) != test.wantErr {
+			t.Errorf("parse of %q got error %v, want error %v", test.body, err, test.wantErr)
+		}
+	}
+}
+
+// TestFollowBinlogSetupError checks a binlog which can not be followed is reported to the reload loop, which then
+// waits for a fail heartbeat instead of reloading at once.
+func TestFollowBinlogSetupError(t *testing.T) {
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s\nsnapshot", filepath.Join(t.TempDir(), "dns.db")))
+	m.openEndpoints()
+	t.Cleanup(m.closeEndpoints)
+	if err := m.followBinlog(newZoneSnapshot(map[string]int{}, map[int]uint32{}), binlogPosition{}); err == nil {
+		t.Error("followed the binlog of a sqlite backend")
+	}
+}
+
+func TestBinlogServerID(t *testing.T) {
+	a, b := newTestMysql(t, "binlog"), newTestMysql(t, "binlog")
+	if a.binlogServerID < binlogServerIDBase || a.binlogServerID == b.binlogServerID {
+		t.Errorf("derived server ids %d and %d, want distinct ids from %d", a.binlogServerID, b.binlogServerID, binlogServerIDBase)
+	}
+	if m := newTestMysql(t, "binlog\nbinlog_server_id 42"); m.binlogServerID != 42 {
+		t.Errorf("server id %d, want the configured 42", m.binlogServerID)
+	}
+}
+
+func TestSaveBinlogPosition(t *testing.T) {
+	m := newTestMysql(t, "binlog")
+	if _, ok := m.loadBinlogPosition(); ok {
+		t.Fatal("loaded a position never saved")
+	}
+	want := binlogPosition{Name: "binlog.000002", Pos: 4242}
+	m.saveBinlogPosition(want)
+	if got, ok := m.loadBinlogPosition(); !ok || got != want {
+		t.Errorf("loaded position %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(m.binlogPositionFile() + dumpTempSuffix); !os.IsNotExist(err) {
+		t.Errorf("temporary position file left behind: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package coredns_mysql_extend
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recordCache is the concurrency safe degrade cache, entries are spread over shards so writers of
+// different names do not contend on a single lock. Each shard keeps its entries in least recently
+// used order and evicts from the back once it holds more than its share of maxEntries.
+type recordCache struct {
+	shards [cacheShards]*cacheShard
+	// maxAge of an entry since it was last written, zero keeps entries forever
+	maxAge time.Duration
+	// size is the count of entries of every shard, the size gauge sums it over the caches not released yet
+	size     int
+	released bool
+	sizeLock sync.Mutex
+}
+
+type cacheShard struct {
+	sync.Mutex
+	entries    map[record]*list.Element
+	lru        *list.List
+	maxEntries int
+}
+
+type cacheEntry struct {
+	key     record
+	info    dnsRecordInfo
+	updated time.Time
+}
+
+// newRecordCache returns a cache holding about maxEntries entries, zero means no limit.
+func newRecordCache(maxEntries int, maxAge time.Duration) *recordCache {
+	c := &recordCache{maxAge: maxAge}
+	shardEntries := zero
+	if maxEntries > zero {
+		shardEntries = (maxEntries + cacheShards - 1) / cacheShards
+	}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{entries: make(map[record]*list.Element), lru: list.New(), maxEntries: shardEntries}
+	}
+	return c
+}
+
+func (c *recordCache) shard(key record) *cacheShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(key.fqdn))
+	hash.Write([]byte(key.qType))
+	return c.shards[hash.Sum32()%cacheShards]
+}
+
+func (c *recordCache) expired(entry *cacheEntry, now time.Time) bool {
+	return c.maxAge > zero && now.Sub(entry.updated) > c.maxAge
+}
+
+func (c *recordCache) get(key record) (dnsRecordInfo, bool) {
+	shard := c.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	element, ok := shard.entries[key]
+	if !ok {
+		return dnsRecordInfo{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.expired(entry, time.Now()) {
+		c.remove(shard, element, "expired")
+		return dnsRecordInfo{}, false
+	}
+	shard.lru.MoveToFront(element)
+	return entry.info, true
+}
+
+func (c *recordCache) set(key record, info dnsRecordInfo) {
+	c.restore(key, info, time.Now())
+}
+
+// restore sets an entry last written at updated, as read back from the dump file.
+func (c *recordCache) restore(key record, info dnsRecordInfo, updated time.Time) {
+	shard := c.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if element, ok := shard.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.info = info
+		entry.updated = updated
+		shard.lru.MoveToFront(element)
+		return
+	}
+	shard.entries[key] = shard.lru.PushFront(&cacheEntry{key: key, info: info, updated: updated})
+	c.resize(1)
+	for shard.maxEntries > zero && shard.lru.Len() > shard.maxEntries {
+		c.remove(shard, shard.lru.Back(), "size")
+	}
+}
+
+// entries returns a copy of every cached entry not expired yet.
+func (c *recordCache) entries() []cacheEntry {
+	var entries []cacheEntry
+	now := time.Now()
+	for _, shard := range c.shards {
+		shard.Lock()
+		for _, element := range shard.entries {
+			entry := element.Value.(*cacheEntry)
+			if c.expired(entry, now) {
+				c.remove(shard, element, "expired")
+				continue
+			}
+			entries = append(entries, *entry)
+		}
+		shard.Unlock()
+	}
+	return entries
+}
+
+// remove drops element from shard, the caller holds the shard lock.
+func (c *recordCache) remove(shard *cacheShard, element *list.Element, reason string) {
+	entry := shard.lru.Remove(element).(*cacheEntry)
+	delete(shard.entries, entry.key)
+	c.resize(-1)
+	degradeCacheEvictionCount.With(prometheus.Labels{"reason": reason}).Inc()
+}
+
+// resize adds delta to the entry count and to the size gauge.
+func (c *recordCache) resize(delta int) {
+	c.sizeLock.Lock()
+	defer c.sizeLock.Unlock()
+	c.size += delta
+	if !c.released {
+		degradeCacheSizeGauge.Add(float64(delta))
+	}
+}
+
+// release removes the entries of the cache from the size gauge, once the plugin owning it is shut down.
+func (c *recordCache) release() {
+	c.sizeLock.Lock()
+	defer c.sizeLock.Unlock()
+	if !c.released {
+		c.released = true
+		degradeCacheSizeGauge.Sub(float64(c.size))
+	}
+}
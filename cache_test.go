@@ -0,0 +1,43 @@
+package coredns_mysql_extend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestRecordCacheSizeGauge checks the size gauge sums the entries of the caches in use, a reload releases the
+// cache of the old plugin.
+func TestRecordCacheSizeGauge(t *testing.T) {
+	base := testutil.ToFloat64(degradeCacheSizeGauge)
+	gauge := func() float64 { return testutil.ToFloat64(degradeCacheSizeGauge) - base }
+
+	old := newRecordCache(zero, zero)
+	for i := 0; i < 10; i++ {
+		old.set(record{fqdn: fmt.Sprintf("host%d.example.org.", i), qType: "A"}, dnsRecordInfo{})
+	}
+	c := newRecordCache(cacheShards, time.Millisecond)
+	for i := 0; i < 4; i++ {
+		c.restore(record{fqdn: fmt.Sprintf("host%d.example.org.", i), qType: "A"}, dnsRecordInfo{}, time.Now().Add(-time.Second))
+	}
+	if got := gauge(); got != 14 {
+		t.Errorf("gauge %v, want 14 for both caches", got)
+	}
+	old.release()
+	old.release()
+	if got := gauge(); got != 4 {
+		t.Errorf("gauge after release %v, want 4", got)
+	}
+	old.set(record{fqdn: "late.example.org.", qType: "A"}, dnsRecordInfo{})
+	if got := gauge(); got != 4 {
+		t.Errorf("gauge after a write to the released cache %v, want 4", got)
+	}
+	if entries := c.entries(); len(entries) != zero {
+		t.Errorf("got %d entries, want the expired ones removed", len(entries))
+	}
+	if got := gauge(); got != zero {
+		t.Errorf("gauge after expiry %v, want 0", got)
+	}
+}
@@ -0,0 +1,153 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// changeTracking reports whether the snapshot is refreshed incrementally from a change column or change table.
+func (m *Mysql) changeTracking() bool {
+	return m.changeColumn != "" || m.changeTable != ""
+}
+
+// changeBackend is implemented by the backends able to pull changed rows for the change tracking refresh.
+type changeBackend interface {
+	ChangeMark(ctx context.Context) (interface{}, error)
+	Changes(ctx context.Context, changeMark interface{}) ([]recordChange, interface{}, error)
+}
+
+// queryChangeMark returns the current high-water mark, rows changed after it are pulled by queryChanges
+// or streamed from the binlog.
+func (m *Mysql) queryChangeMark() (interface{}, error) {
+	if !m.binlogMode && !m.changeTracking() {
+		return nil, nil
+	}
+	backend, err := m.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, m.queryTimeout)
+	defer cancel()
+	if m.binlogMode {
+		mysqlBackend, ok := backend.(*mysqlBackend)
+		if !ok {
+			return nil, errors.New("binlog needs the mysql backend")
+		}
+		return mysqlBackend.MasterPosition(ctx)
+	}
+	changeBackend, ok := backend.(changeBackend)
+	if !ok {
+		return nil, errors.New("backend does not support change tracking")
+	}
+	return changeBackend.ChangeMark(ctx)
+}
+
+// queryChanges returns the rows changed since changeMark and the new high-water mark.
+func (m *Mysql) queryChanges(changeMark interface{}) ([]recordChange, interface{}, error) {
+	backend, err := m.getBackend()
+	if err != nil {
+		return nil, changeMark, err
+	}
+	changeBackend, ok := backend.(changeBackend)
+	if !ok {
+		return nil, changeMark, errors.New("backend does not support change tracking")
+	}
+	return changeBackend.Changes(m.ctx, changeMark)
+}
+
+// reApplyChanges polls for changed rows and applies them to snapshot until a full reload is needed.
+// A full reload is forced every success heartbeat time, it removes the rows deleted from the records table and
+// the rows committed behind the change mark, which are never pulled as changes.
+func (m *Mysql) reApplyChanges(snapshot *zoneSnapshot, changeMark interface{}) {
+	reconcile := time.Now().Add(m.successHeartbeatTime)
+	for {
+		if !m.sleep(m.changeHeartbeatTime) {
+			return
+		}
+		if time.Now().After(reconcile) {
+			logger.Debugf("Reload zone snapshot to reconcile the record changes")
+			return
+		}
+
+		// Records of a new zone may predate it, so zone changes trigger a full reload
+		zoneMap, zoneTTLs, err := m.queryZones()
+		if err != nil {
+			logger.Errorf("Failed to query zones: %s", err)
+			dbGetZoneCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			continue
+		}
+		dbGetZoneCount.With(prometheus.Labels{"status": "success"}).Inc()
+		if !reflect.DeepEqual(zoneMap, snapshot.zoneMap) || !reflect.DeepEqual(zoneTTLs, snapshot.zoneTTLs) {
+			logger.Infof("Zones changed, reload zone snapshot")
+			return
+		}
+
+		changes, nextMark, err := m.queryChanges(changeMark)
+		if err != nil {
+			logger.Errorf("Failed to query record changes: %s", err)
+			changeApplyCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			continue
+		}
+		changeMark = nextMark
+
+		changes = snapshot.pending(changes, m.soaSerial != "")
+		if len(changes) == zero {
+			continue
+		}
+		snapshot = snapshot.apply(changes)
+		m.swapSnapshot(snapshot)
+		logger.Debugf("Success to apply %d record changes, change mark %v", len(changes), changeMark)
+		changeApplyCount.With(prometheus.Labels{"status": "success"}).Inc()
+	}
+}
+
+// pending filters out changes already reflected by the snapshot. With computed serials the SOA records of the
+// snapshot are rewritten, a SOA row only differing by an older serial is already reflected.
+func (s *zoneSnapshot) pending(changes []recordChange, computedSerials bool) []recordChange {
+	var result []recordChange
+	for _, change := range changes {
+		current, ok := s.byID[change.record.id]
+		if !change.online && !ok {
+			continue
+		}
+		if change.online && ok && current.zoneID == change.record.zoneID && current.name == change.record.name &&
+			current.qType == change.record.qType && current.ttl == change.record.ttl &&
+			(current.data == change.record.data || computedSerials && sameSOA(current, change.record)) {
+			continue
+		}
+		result = append(result, change)
+	}
+	return result
+}
+
+// sameSOA reports whether the SOA record stored only differs from the applied one by a serial not newer.
+func sameSOA(applied, stored record) bool {
+	if !strings.EqualFold(applied.qType, soaQtype) {
+		return false
+	}
+	appliedRR, err := dns.NewRR(fmt.Sprintf(". 0 IN %s %s", soaQtype, applied.data))
+	if err != nil {
+		return false
+	}
+	storedRR, err := dns.NewRR(fmt.Sprintf(". 0 IN %s %s", soaQtype, stored.data))
+	if err != nil {
+		return false
+	}
+	appliedSOA, ok := appliedRR.(*dns.SOA)
+	if !ok {
+		return false
+	}
+	storedSOA, ok := storedRR.(*dns.SOA)
+	if !ok || serialNewer(storedSOA.Serial, appliedSOA.Serial) {
+		return false
+	}
+	storedSOA.Serial = appliedSOA.Serial
+	return dns.IsDuplicate(appliedSOA, storedSOA)
+}
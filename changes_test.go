@@ -0,0 +1,85 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestChangeColumnReconcile checks a row deleted from the records table is removed by the periodic full reload,
+// the change column never shows it.
+func TestChangeColumnReconcile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dns.db")
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s\nchange_column updated_at\nchange_heartbeat_time 10ms\nsuccess_heartbeat_time 200ms", file))
+	m.openEndpoints()
+	backend, _ := m.getBackend()
+	backend.CreateSchema(context.Background())
+	db := backend.(*sqliteBackend).db
+	for _, statement := range []string{
+		"ALTER TABLE records ADD COLUMN updated_at TEXT NOT NULL DEFAULT '2026-01-01 00:00:00'",
+		"INSERT INTO zones(id, zone_name) VALUES(1, 'example.org.')",
+		"INSERT INTO records(id, zone_id, hostname, type, data, ttl, online) VALUES(1, 1, 'www', 'A', '10.0.0.1', 60, 1)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.startWorker(m.reLoadSnapshot)
+	defer func() {
+		m.cancel()
+		m.workers.Wait()
+		m.closeEndpoints()
+	}()
+
+	waitFor := func(what string, done func(*zoneSnapshot) bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second * 2)
+		for snapshot := m.snapshot.Load(); snapshot == nil || !done(snapshot); snapshot = m.snapshot.Load() {
+			if time.Now().After(deadline) {
+				t.Fatal(what)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	waitFor("record not loaded", func(s *zoneSnapshot) bool { return len(s.getRecords(1, "www", "A")) > zero })
+	if _, err := db.Exec("DELETE FROM records WHERE id=1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("deleted record not removed", func(s *zoneSnapshot) bool { return len(s.getRecords(1, "www", "A")) == zero })
+}
+
+// TestPendingComputedSerial checks a SOA row only differing from the snapshot by its serial, rewritten without
+// write back, is not applied again on every poll.
+func TestPendingComputedSerial(t *testing.T) {
+	m := newTestMysql(t, "snapshot\nsoa_serial date")
+	soa := record{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60}
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	snapshot.add(soa)
+	m.swapSnapshot(snapshot)
+	base := m.baseSerial(time.Now())
+	if serial, _ := m.snapshot.Load().serial(1); serial != base {
+		t.Fatalf("serial %d, want %d", serial, base)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"stored serial", "ns.example.org. h.example.org. 1 3600 600 86400 300", 0},
+		{"serial bumped by hand", fmt.Sprintf("ns.example.org. h.example.org. %d 3600 600 86400 300", base+100), 1},
+		{"other field", "ns2.example.org. h.example.org. 1 3600 600 86400 300", 1},
+	}
+	for _, test := range tests {
+		change := soa
+		change.data = test.data
+		if got := m.snapshot.Load().pending([]recordChange{{record: change, online: true}}, true); len(got) != test.want {
+			t.Errorf("%s: got %d pending changes, want %d", test.name, len(got), test.want)
+		}
+	}
+	if got := m.snapshot.Load().pending([]recordChange{{record: soa, online: true}}, false); len(got) != 1 {
+		t.Errorf("got %d pending changes without computed serials, want 1", len(got))
+	}
+}
@@ -0,0 +1,212 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errCNAMELoop     = errors.New("cname loop")
+	errDNAMEOverflow = errors.New("dname substitution is too long")
+)
+
+// lookup returns the records of qType of qName, or its CNAME records if it has none of qType. A name that does
+// not exist is redirected by the DNAME record of an ancestor, returned in place of the CNAME records, or else
+// synthesized from a wildcard. It also returns whether qName exists, so a negative answer is NODATA.
+func (m *Mysql) lookup(ctx context.Context, qName string, zoneID int, host, zone, qType string) ([]record, []record, bool, error) {
+	if qType == anyQtype {
+		return m.lookupAny(ctx, qName, zoneID, host, zone)
+	}
+	// The DNSKEY rrset of a signed zone comes from its key files
+	if keys := m.zoneKeys(zone); keys != nil && qType == dnskeyQtype && host == zoneSelf {
+		return keys.dnskeyRecords(zoneID, zone), nil, true, nil
+	}
+	records, err := m.getRecords(ctx, zoneID, host, zone, qType)
+	if err != nil || len(records) > zero {
+		return records, nil, true, err
+	}
+	cnameRecords, err := m.getRecords(ctx, zoneID, host, zone, cnameQtype)
+	if err != nil || len(cnameRecords) > zero {
+		return nil, cnameRecords, true, err
+	}
+	// The names below a DNAME are not supposed to own records, it is only looked for names without any
+	dnameRecords, err := m.findDNAME(ctx, zoneID, host, zone)
+	if err != nil || len(dnameRecords) > zero {
+		return nil, dnameRecords, true, err
+	}
+	wildcardRecords, exists, err := m.wildcardRecords(ctx, qName, zoneID, host, zone)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return filterRecords(wildcardRecords, qType), filterRecords(wildcardRecords, cnameQtype), exists, nil
+}
+
+// lookupAny is lookup for qtype ANY, which matches the records of every type including CNAME.
+func (m *Mysql) lookupAny(ctx context.Context, qName string, zoneID int, host, zone string) ([]record, []record, bool, error) {
+	records, err := m.getHostRecords(ctx, zoneID, host, zone)
+	if err != nil || len(records) > zero {
+		return m.anyRecords(qName, zoneID, records), nil, true, err
+	}
+	dnameRecords, err := m.findDNAME(ctx, zoneID, host, zone)
+	if err != nil || len(dnameRecords) > zero {
+		return nil, dnameRecords, true, err
+	}
+	wildcardRecords, exists, err := m.wildcardRecords(ctx, qName, zoneID, host, zone)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return m.anyRecords(qName, zoneID, wildcardRecords), nil, exists, nil
+}
+
+// anyRecords reduces the records of qName answered to qtype ANY according to any_response, RFC 8482 section 4.
+func (m *Mysql) anyRecords(qName string, zoneID int, records []record) []record {
+	if len(records) == zero {
+		return nil
+	}
+	switch m.anyResponse {
+	case allAnyResponse:
+		return records
+	case hinfoAnyResponse:
+		// The ttl is left to the default ttl of the zone
+		return []record{{zoneID: zoneID, fqdn: qName, qType: hinfoQtype, data: rfc8482HINFO}}
+	default:
+		return filterRecords(records, records[zero].qType)
+	}
+}
+
+// findDNAME returns the DNAME records of the highest ancestor of host owning some, RFC 6672 section 3.2.
+func (m *Mysql) findDNAME(ctx context.Context, zoneID int, host, zone string) ([]record, error) {
+	if host == zoneSelf {
+		return nil, nil
+	}
+	ancestors := []string{zoneSelf}
+	labels := strings.Split(host, zoneSeparator)
+	for i := len(labels) - 1; i > zero; i-- {
+		ancestors = append(ancestors, strings.Join(labels[i:], zoneSeparator))
+	}
+	for _, ancestor := range ancestors {
+		records, err := m.getRecords(ctx, zoneID, ancestor, zone, dnameQtype)
+		if err != nil || len(records) > zero {
+			return records, err
+		}
+	}
+	return nil, nil
+}
+
+// cnameTarget is the last target of a CNAME chain when it is in our zones but owns no records of the question
+// type, the answer takes its rcode and the SOA of its zone, RFC 2308 section 2.1 and RFC 6604.
+type cnameTarget struct {
+	name   string
+	zoneID int
+	host   string
+	zone   string
+	// exists tells NODATA from NXDOMAIN
+	exists bool
+}
+
+// chaseCNAME follows the CNAME chain of owner for up to cname_max_depth targets, and returns the whole chain
+// followed by the records of qType of its last target, or the last target if it has none. A target outside of our zones is resolved by the next
+// plugin if cname_external is next, otherwise the chain is left for the client to follow. A DNAME in the chain
+// is answered followed by the CNAME it synthesizes. A chain coming back to one of its names returns errCNAMELoop.
+func (m *Mysql) chaseCNAME(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, owner string, cnameRecords []record, qType string) ([]dns.RR, []string, *cnameTarget, error) {
+	var (
+		answers   []dns.RR
+		rrStrings []string
+	)
+	seen := map[string]bool{strings.ToLower(owner): true}
+	for depth := 1; ; depth++ {
+		// A name owns a single CNAME or DNAME, RFC 2181 section 10.1 and RFC 6672 section 2.4
+		cnameRecord := cnameRecords[zero]
+		ttl := m.recordTTL(cnameRecord)
+		target := dns.Fqdn(cnameRecord.data)
+		if strings.EqualFold(cnameRecord.qType, dnameQtype) {
+			dnameAnswers, dnameStrings := m.makeAnswers(cnameRecords[:1])
+			answers = append(answers, dnameAnswers...)
+			rrStrings = append(rrStrings, dnameStrings...)
+			// RFC 6672 section 2.2, the owner of the DNAME in owner is replaced by its target
+			target = owner[:len(owner)-len(cnameRecord.fqdn)] + target
+			if _, ok := dns.IsDomainName(target); !ok {
+				return answers, rrStrings, nil, fmt.Errorf("%w: %s", errDNAMEOverflow, target)
+			}
+		}
+		rrString := fmt.Sprintf("%s %d IN %s %s", owner, ttl, cnameQtype, target)
+		// A target which is not a domain name ends the chain
+		rr, err := m.makeAnswer(rrString)
+		if err != nil || rr == nil {
+			return answers, rrStrings, nil, nil
+		}
+		answers = append(answers, rr)
+		rrStrings = append(rrStrings, rrString)
+
+		target = strings.ToLower(target)
+		if seen[target] {
+			cnameChainCount.With(prometheus.Labels{"result": "loop"}).Inc()
+			return nil, nil, nil, fmt.Errorf("%w at %s", errCNAMELoop, target)
+		}
+		seen[target] = true
+
+		zoneID, host, zone, ok := m.findZone(target)
+		if !ok {
+			cnameChainCount.With(prometheus.Labels{"result": "external"}).Inc()
+			if m.cnameExternal == nextExternal {
+				externalAnswers := m.externalAnswers(ctx, w, r, target)
+				for _, rr := range externalAnswers {
+					rrStrings = append(rrStrings, rr.String())
+				}
+				answers = append(answers, externalAnswers...)
+			}
+			return answers, rrStrings, nil, nil
+		}
+		records, nextCNAMERecords, exists, err := m.lookup(ctx, target, zoneID, host, zone, qType)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(records) == zero && len(nextCNAMERecords) == zero {
+			cnameChainCount.With(prometheus.Labels{"result": "negative"}).Inc()
+			return answers, rrStrings, &cnameTarget{name: target, zoneID: zoneID, host: host, zone: zone, exists: exists}, nil
+		}
+		if len(records) > zero {
+			cnameChainCount.With(prometheus.Labels{"result": "resolved"}).Inc()
+			recordAnswers, recordStrings := m.makeAnswers(records)
+			return append(answers, recordAnswers...), append(rrStrings, recordStrings...), nil, nil
+		}
+		if depth >= m.cnameMaxDepth {
+			cnameChainCount.With(prometheus.Labels{"result": "depth"}).Inc()
+			logger.Debugf("CNAME chain of %s is longer than %d, left to the client", r.Question[zero].Name, m.cnameMaxDepth)
+			return answers, rrStrings, nil, nil
+		}
+		owner, cnameRecords = target, nextCNAMERecords
+	}
+}
+
+// externalAnswers asks the next plugin for the answers of target, with the type of the question.
+func (m *Mysql) externalAnswers(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, target string) []dns.RR {
+	if m.Next == nil {
+		return nil
+	}
+	req := r.Copy()
+	req.Question[zero].Name = target
+	nw := nonwriter.New(w)
+	if _, err := plugin.NextOrFailure(m.Name(), m.Next, ctx, nw, req); err != nil || nw.Msg == nil {
+		logger.Debugf("Next plugin can not resolve CNAME target %s: %v", target, err)
+		return nil
+	}
+	return nw.Msg.Answer
+}
+
+func filterRecords(records []record, qType string) []record {
+	var filtered []record
+	for _, record := range records {
+		if strings.EqualFold(record.qType, qType) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,175 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// TestCNAMENegative checks a chain ending at a name without records is answered with the rcode of that name and
+// the SOA of its zone.
+func TestCNAMENegative(t *testing.T) {
+	m := newTestMysql(t, "snapshot")
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1, "example.net.": 2}, map[int]uint32{})
+	for _, record := range []record{
+		{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+		{id: 2, zoneID: 2, name: zoneSelf, qType: soaQtype, data: "ns.example.net. h.example.net. 1 3600 600 86400 300", ttl: 60},
+		{id: 3, zoneID: 1, name: "missing", qType: cnameQtype, data: "missing.example.net.", ttl: 60},
+		{id: 4, zoneID: 1, name: "nodata", qType: cnameQtype, data: "www.example.net.", ttl: 60},
+		{id: 5, zoneID: 2, name: "www", qType: "AAAA", data: "::1", ttl: 60},
+	} {
+		snapshot.add(record)
+	}
+	m.swapSnapshot(snapshot)
+
+	tests := []struct {
+		name  string
+		rcode int
+	}{
+		{"missing.example.org.", dns.RcodeNameError},
+		{"nodata.example.org.", dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tt.name, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := m.ServeDNS(context.Background(), rec, r); err != nil {
+			t.Fatal(err)
+		}
+		msg := rec.Msg
+		if msg.Rcode != tt.rcode {
+			t.Errorf("%s: rcode %s, want %s", tt.name, dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.rcode])
+		}
+		if len(msg.Answer) != 1 || msg.Answer[zero].Header().Rrtype != dns.TypeCNAME {
+			t.Errorf("%s: answer %v, want the CNAME", tt.name, msg.Answer)
+		}
+		if len(msg.Ns) != 1 || msg.Ns[zero].Header().Rrtype != dns.TypeSOA || msg.Ns[zero].Header().Name != "example.net." {
+			t.Errorf("%s: authority %v, want the SOA of example.net.", tt.name, msg.Ns)
+		}
+	}
+}
+
+// TestCNAMEBadData checks a CNAME row whose data is not a domain name ends the chain instead of answering a nil
+// record.
+func TestCNAMEBadData(t *testing.T) {
+	m := newTestMysql(t, "snapshot")
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	for _, record := range []record{
+		{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+		{id: 2, zoneID: 1, name: "bad", qType: cnameQtype, data: "foo bar", ttl: 60},
+	} {
+		snapshot.add(record)
+	}
+	m.swapSnapshot(snapshot)
+
+	r := new(dns.Msg)
+	r.SetQuestion("bad.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := m.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != zero {
+		t.Errorf("got rcode %s and answer %v, want NODATA", dns.RcodeToString[rec.Msg.Rcode], rec.Msg.Answer)
+	}
+}
+
+func TestDNAME(t *testing.T) {
+	label := strings.Repeat("a", 63)
+	// A target of 250 octets, a label below the owner can not be substituted in it
+	long := strings.Join([]string{label, label, label, strings.Repeat("b", 56)}, ".") + "."
+	m := newSnapshotMysql(t, "", map[string]int{"example.org.": 1},
+		record{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+		record{id: 2, zoneID: 1, name: "old", qType: dnameQtype, data: "new.example.org.", ttl: 60},
+		record{id: 3, zoneID: 1, name: "www.new", qType: "A", data: "10.0.0.1", ttl: 60},
+		record{id: 4, zoneID: 1, name: "ext", qType: dnameQtype, data: "example.net.", ttl: 60},
+		record{id: 5, zoneID: 1, name: "long", qType: dnameQtype, data: long, ttl: 60},
+	)
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"www.old.example.org.", dns.TypeA, dns.RcodeSuccess, []string{
+			"old.example.org.\t60\tIN\tDNAME\tnew.example.org.",
+			"www.old.example.org.\t60\tIN\tCNAME\twww.new.example.org.",
+			"www.new.example.org.\t60\tIN\tA\t10.0.0.1",
+		}},
+		// The names below the DNAME are redirected, not its owner
+		{"old.example.org.", dns.TypeDNAME, dns.RcodeSuccess, []string{"old.example.org.\t60\tIN\tDNAME\tnew.example.org."}},
+		{"old.example.org.", dns.TypeA, dns.RcodeSuccess, nil},
+		// The target outside of our zones is left to the client
+		{"a.b.ext.example.org.", dns.TypeA, dns.RcodeSuccess, []string{
+			"ext.example.org.\t60\tIN\tDNAME\texample.net.",
+			"a.b.ext.example.org.\t60\tIN\tCNAME\ta.b.example.net.",
+		}},
+		// RFC 6672 section 2.2, the substituted name is too long
+		{label + ".long.example.org.", dns.TypeA, dns.RcodeYXDomain, []string{"long.example.org.\t60\tIN\tDNAME\t" + long}},
+	}
+	for _, tt := range tests {
+		msg := exchange(t, m, tt.name, tt.qType)
+		qType := dns.TypeToString[tt.qType]
+		if msg.Rcode != tt.rcode {
+			t.Errorf("%s %s: rcode %s, want %s", tt.name, qType, dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.rcode])
+		}
+		if got := rrStrings(msg.Answer); !reflect.DeepEqual(got, tt.answer) {
+			t.Errorf("%s %s: answer %q, want %q", tt.name, qType, got, tt.answer)
+		}
+	}
+}
+
+func TestAnyResponse(t *testing.T) {
+	records := []record{
+		{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+		{id: 2, zoneID: 1, name: "www", qType: "A", data: "10.0.0.1", ttl: 60},
+		{id: 3, zoneID: 1, name: "www", qType: "A", data: "10.0.0.2", ttl: 60},
+		{id: 4, zoneID: 1, name: "www", qType: "AAAA", data: "2001:db8::1", ttl: 60},
+		{id: 5, zoneID: 1, name: "www", qType: "TXT", data: `"text"`, ttl: 60},
+	}
+	zones := map[string]int{"example.org.": 1}
+
+	minimal := exchange(t, newSnapshotMysql(t, "", zones, records...), "www.example.org.", dns.TypeANY)
+	if len(minimal.Answer) == zero {
+		t.Fatal("minimal ANY answered nothing")
+	}
+	for _, rr := range minimal.Answer {
+		if rr.Header().Rrtype != minimal.Answer[zero].Header().Rrtype {
+			t.Errorf("minimal ANY answered more than one rrset: %q", rrStrings(minimal.Answer))
+			break
+		}
+	}
+
+	hinfo := exchange(t, newSnapshotMysql(t, "any_response hinfo", zones, records...), "www.example.org.", dns.TypeANY)
+	if len(hinfo.Answer) != 1 {
+		t.Fatalf("hinfo ANY answer %q, want one HINFO", rrStrings(hinfo.Answer))
+	}
+	if rr, ok := hinfo.Answer[zero].(*dns.HINFO); !ok || rr.Cpu != "RFC8482" || rr.Os != "" || rr.Hdr.Name != "www.example.org." {
+		t.Errorf("hinfo ANY answer %q, want HINFO \"RFC8482\" \"\"", rrStrings(hinfo.Answer))
+	}
+
+	m := newSnapshotMysql(t, "any_response all", zones, records...)
+	all := exchange(t, m, "www.example.org.", dns.TypeANY)
+	types := map[uint16]int{}
+	for _, rr := range all.Answer {
+		types[rr.Header().Rrtype]++
+	}
+	if want := map[uint16]int{dns.TypeA: 2, dns.TypeAAAA: 1, dns.TypeTXT: 1}; !reflect.DeepEqual(types, want) {
+		t.Errorf("all ANY answer %q, want every record of www", rrStrings(all.Answer))
+	}
+
+	if msg := exchange(t, m, "none.example.org.", dns.TypeANY); msg.Rcode != dns.RcodeNameError || len(msg.Answer) != zero {
+		t.Errorf("ANY of a missing name got rcode %s answer %q, want NXDOMAIN", dns.RcodeToString[msg.Rcode], rrStrings(msg.Answer))
+	}
+
+	c := caddy.NewTestController("dns", "mysql {\nany_response some\n}")
+	if err := MakeMysqlPlugin().parseConfig(c); err == nil {
+		t.Error("parsed an unknown any_response")
+	}
+}
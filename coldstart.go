@@ -0,0 +1,86 @@
+package coredns_mysql_extend
+
+// dumpRecords lists every record of the snapshot for the cold start data of the dump file.
+func (s *zoneSnapshot) dumpRecords() []dumpRecord {
+	records := make([]dumpRecord, zero, len(s.byID))
+	for _, record := range s.byID {
+		records = append(records, dumpRecord{
+			ID:     record.id,
+			ZoneID: record.zoneID,
+			Name:   record.name,
+			Type:   record.qType,
+			Data:   record.data,
+			TTL:    record.ttl,
+		})
+	}
+	return records
+}
+
+// snapshotFromDump rebuilds the zones and records saved in a dump file, it returns nil if the dump has none.
+func snapshotFromDump(content dumpContent) *zoneSnapshot {
+	if len(content.Zones) == zero || len(content.Records) == zero {
+		return nil
+	}
+	zoneMap := make(map[string]int, len(content.Zones))
+	zoneTTLs := make(map[int]uint32)
+	for _, zone := range content.Zones {
+		zoneMap[zone.Name] = zone.ID
+		if zone.TTL > zero {
+			zoneTTLs[zone.ID] = zone.TTL
+		}
+	}
+	snapshot := newZoneSnapshot(zoneMap, zoneTTLs)
+	for _, dumpRecord := range content.Records {
+		record := record{
+			id:     dumpRecord.ID,
+			zoneID: dumpRecord.ZoneID,
+			name:   dumpRecord.Name,
+			qType:  dumpRecord.Type,
+			data:   dumpRecord.Data,
+			ttl:    dumpRecord.TTL,
+		}
+		if !snapshot.add(record) {
+			logger.Warningf("Dumped record %d references unknown zone id %d", record.id, record.zoneID)
+		}
+	}
+	return snapshot
+}
+
+// coldStart answers queries from the zones and records of the dump file until the database is reachable.
+func (m *Mysql) coldStart(content dumpContent) {
+	snapshot := snapshotFromDump(content)
+	if snapshot == nil {
+		logger.Warningf("Dump file has no records, can not cold start")
+		return
+	}
+	m.swapSnapshot(snapshot)
+	m.dumpSnapshot.Store(snapshot)
+	logger.Infof("Cold start from dump file: %d zones, %d records", len(snapshot.zoneMap), snapshot.count())
+}
+
+// leaveColdStart switches to live data once the database is reachable, outside of snapshot mode.
+func (m *Mysql) leaveColdStart() {
+	if m.snapshot.Swap(nil) != nil {
+		logger.Infof("Database is reachable, leave cold start")
+	}
+}
+
+// coldStartSnapshot returns the zones and records to save in the dump file for the next cold start.
+// Outside of snapshot mode they are the ones of the last refreshColdStart, a dump never loads them.
+func (m *Mysql) coldStartSnapshot() *zoneSnapshot {
+	if m.snapshotMode {
+		return m.snapshot.Load()
+	}
+	return m.dumpSnapshot.Load()
+}
+
+// refreshColdStart loads the zones and records for the next cold start outside of snapshot mode, the previous
+// ones are kept if the database is unreachable.
+func (m *Mysql) refreshColdStart() {
+	snapshot, err := m.loadSnapshot()
+	if err != nil {
+		logger.Warningf("Failed to load records for cold start, keep the previous ones: %s", err)
+		return
+	}
+	m.dumpSnapshot.Store(snapshot)
+}
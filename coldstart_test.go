@@ -0,0 +1,71 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// hungBackend is a database whose record queries hang until their context is done.
+type hungBackend struct{}
+
+func (hungBackend) ListZones(ctx context.Context) (map[string]int, map[int]uint32, error) {
+	return map[string]int{"example.org.": 1}, map[int]uint32{}, nil
+}
+
+func (hungBackend) LookupRecords(ctx context.Context, zoneID int, zone, host, qType string) ([]record, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungBackend) NameExists(ctx context.Context, zoneID int, host string) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func (hungBackend) ListRecords(ctx context.Context) ([]record, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungBackend) ListZoneRecords(ctx context.Context, zoneID int, zone string) ([]record, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungBackend) UpdateRecordData(ctx context.Context, id int, data string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (hungBackend) Ping(ctx context.Context) error { return nil }
+
+func (hungBackend) CreateSchema(ctx context.Context) {}
+
+func (hungBackend) Close() error { return nil }
+
+// TestColdStartDump checks a dump outside of snapshot mode saves the last loaded records without touching the
+// database, so shut down does not wait for a hung one.
+func TestColdStartDump(t *testing.T) {
+	m := newTestMysql(t, "cold_start")
+	m.backend = hungBackend{}
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	snapshot.add(record{id: 1, zoneID: 1, name: "www", qType: "A", data: "10.0.0.1", ttl: 60})
+	m.dumpSnapshot.Store(snapshot)
+
+	done := make(chan error, 1)
+	go func() { done <- m.onShutdown() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shut down blocked")
+	}
+
+	content, _, err := loadDumpFile(m.dumpFile, m.dumpGenerations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content.Records) != 1 || content.Records[0].Data != "10.0.0.1" {
+		t.Errorf("dumped records %+v, want the loaded one", content.Records)
+	}
+}
@@ -3,6 +3,7 @@ package coredns_mysql_extend
 import "time"
 
 const (
+	defaultDriver               = mysqlDriver
 	defaultDSN                  = "username:password@tcp(127.0.0.1:3306)/dns"
 	defaultDumpFile             = "dump_dns.json"
 	defaultTTL                  = 360
@@ -14,9 +15,74 @@ const (
 	defaultConnMaxLifeTime      = time.Hour * 24
 	defaultFailHeartBeatTime    = time.Second * 10
 	defaultSuccessHeartBeatTime = time.Second * 60
+	defaultChangeHeartBeatTime  = time.Second * 5
+	defaultQueryTimeout         = time.Second * 1
+	defaultDegradeMaxEntries    = 100000
+	defaultDumpInterval         = time.Minute * 1
+	defaultDumpGenerations      = 3
+	defaultCNAMEMaxDepth        = 8
+
+	binlogFlushTime      = time.Millisecond * 100
+	binlogPositionSuffix = ".binlog.pos"
+	// binlogServerIDBase is the lowest derived replication server id, the ids below are left to binlog_server_id
+	binlogServerIDBase = 1 << 16
+
+	cacheShards = 16
+
+	dumpFormatVersion = 2
+	dumpTempSuffix    = ".tmp"
+
+	noneCompression = "none"
+	gzipCompression = "gzip"
+	zstdCompression = "zstd"
+
+	clientExternal = "client"
+	nextExternal   = "next"
+
+	// Signatures are valid from signatureInceptionSkew before signing, to allow for clock skew, and are
+	// signed again after signatureRefresh, well before they expire
+	signatureValidity      = time.Hour * 24 * 8
+	signatureInceptionSkew = time.Hour * 3
+	signatureRefresh       = time.Hour * 24 * 2
+	signatureCacheEntries  = 10000
+	// nxnameType is the NXNAME pseudo type of the compact denial of existence, RFC 9824 section 2
+	nxnameType = 128
+
+	transferTimeout   = time.Second * 30
+	transferChunk     = 500
+	ixfrJournalDeltas = 64
+
+	notifyAttempts  = 5
+	notifyRetryTime = time.Second * 2
+	notifyTimeout   = time.Second * 2
+
+	dateSOASerial      = "date"
+	unixtimeSOASerial  = "unixtime"
+	writeBackSOASerial = "write_back"
+
+	minimalAnyResponse = "minimal"
+	hinfoAnyResponse   = "hinfo"
+	allAnyResponse     = "all"
+	rfc8482HINFO       = `"RFC8482" ""`
 
 	defaultQueryZoneSQL   = "SELECT id, zone_name FROM %s"
 	defaultQueryRecordSQL = "SELECT id, zone_id, hostname, type, data, ttl FROM  %s WHERE online!=0 and zone_id=? and hostname=? and type=?"
+	defaultQueryHostSQL   = "SELECT id, zone_id, hostname, type, data, ttl FROM  %s WHERE online!=0 and zone_id=? and hostname=?"
+
+	defaultQueryAllRecordSQL  = "SELECT id, zone_id, hostname, type, data, ttl FROM  %s WHERE online!=0"
+	defaultQueryZoneRecordSQL = "SELECT id, zone_id, hostname, type, data, ttl FROM  %s WHERE online!=0 and zone_id=?"
+	defaultQueryNameSQL       = "SELECT 1 FROM  %s WHERE online!=0 and zone_id=? and (hostname=? or hostname LIKE ? ESCAPE '!') LIMIT 1"
+	defaultUpdateRecordSQL    = "UPDATE %s SET data=? WHERE id=?"
+
+	// %[1]s is the zones table, %[2]s is the zone ttl column
+	defaultQueryZoneTTLSQL = "SELECT id, zone_name, %[2]s FROM %[1]s"
+
+	// %[1]s is the records table, %[2]s is the change column or the change table
+	defaultQueryChangeColumnSQL     = "SELECT id, zone_id, hostname, type, data, ttl, online, %[2]s FROM  %[1]s WHERE %[2]s>=? ORDER BY %[2]s"
+	defaultQueryChangeColumnMarkSQL = "SELECT MAX(%[2]s) FROM  %[1]s"
+	defaultQueryChangeTableSQL      = "SELECT c.record_id, r.zone_id, r.hostname, r.type, r.data, r.ttl, r.online, c.id FROM  %[2]s c LEFT JOIN %[1]s r ON r.id=c.record_id WHERE c.id>? ORDER BY c.id"
+	defaultQueryChangeTableMarkSQL  = "SELECT COALESCE(MAX(id), 0) FROM  %[2]s"
+	zeroChangeMark                  = "1970-01-01 00:00:00"
 
 	zero          = 0
 	zeroTime      = zero
@@ -27,5 +93,22 @@ const (
 	wildcard      = "*"
 	zoneSelf      = "@"
 	cnameQtype    = "CNAME"
+	dnameQtype    = "DNAME"
+	anyQtype      = "ANY"
+	hinfoQtype    = "HINFO"
+	dnskeyQtype   = "DNSKEY"
+	axfrQtype     = "AXFR"
+	ixfrQtype     = "IXFR"
+	soaQtype      = "SOA"
+	nsQtype       = "NS"
+	aQtype        = "A"
+	aaaaQtype     = "AAAA"
 	pluginName    = "mysql"
+
+	mysqlDriver    = "mysql"
+	postgresDriver = "postgres"
+	sqliteDriver   = "sqlite"
+
+	primaryRole = "primary"
+	replicaRole = "replica"
 )
@@ -0,0 +1,61 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// compactDenial proves the negative answer msg of a signed zone with a single NSEC record owned by qName and
+// covering nothing else, RFC 9824. A name that does not exist is answered NODATA with the NXNAME pseudo type
+// in the bitmap, the bitmap of an existing name lists the types it owns. The NSEC is signed with the rest of
+// msg, no zone walk is possible and no name set of the zone is needed.
+func (m *Mysql) compactDenial(ctx context.Context, msg *dns.Msg, qName string, zoneID int, host, zone string, nameExists bool) error {
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if nameExists {
+		records, err := m.getHostRecords(ctx, zoneID, host, zone)
+		if err != nil {
+			return err
+		}
+		// An empty non-terminal or a wildcard expansion
+		if len(records) == zero {
+			if records, _, err = m.wildcardRecords(ctx, qName, zoneID, host, zone); err != nil {
+				return err
+			}
+		}
+		for _, record := range records {
+			if qType, ok := dns.StringToType[strings.ToUpper(record.qType)]; ok {
+				types = append(types, qType)
+			}
+		}
+		if host == zoneSelf {
+			types = append(types, dns.TypeDNSKEY)
+		}
+	} else {
+		types = append(types, nxnameType)
+		msg.Rcode = dns.RcodeSuccess
+	}
+
+	// The NSEC ttl is the negative ttl, RFC 9077
+	ttl := msg.Ns[zero].Header().Ttl
+	msg.Ns = append(msg.Ns, &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: qName, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: `\000.` + qName,
+		TypeBitMap: typeBitMap(types),
+	})
+	return nil
+}
+
+// typeBitMap sorts types and removes the duplicates, as the NSEC type bitmap lists them.
+func typeBitMap(types []uint16) []uint16 {
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	bitMap := types[:zero]
+	for i, qType := range types {
+		if i == zero || qType != types[i-1] {
+			bitMap = append(bitMap, qType)
+		}
+	}
+	return bitMap
+}
@@ -0,0 +1,98 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCompactDenial(t *testing.T) {
+	m := newTestMysql(t, "snapshot")
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	for _, record := range []record{
+		{id: 1, zoneID: 1, name: "www", qType: "a", data: "10.0.0.1", ttl: 60},
+		{id: 2, zoneID: 1, name: "www", qType: "TXT", data: `"hello"`, ttl: 60},
+		{id: 3, zoneID: 1, name: "a.b", qType: "A", data: "10.0.0.2", ttl: 60},
+	} {
+		snapshot.add(record)
+	}
+	m.swapSnapshot(snapshot)
+
+	tests := []struct {
+		name   string
+		host   string
+		exists bool
+		want   []uint16
+	}{
+		{"www.example.org.", "www", true, []uint16{dns.TypeA, dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}},
+		{"b.example.org.", "b", true, []uint16{dns.TypeRRSIG, dns.TypeNSEC}},
+		{"nx.example.org.", "nx", false, []uint16{dns.TypeRRSIG, dns.TypeNSEC, nxnameType}},
+	}
+	for _, test := range tests {
+		msg := new(dns.Msg)
+		if !test.exists {
+			msg.Rcode = dns.RcodeNameError
+		}
+		msg.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300}}}
+		if err := m.compactDenial(context.Background(), msg, test.name, 1, test.host, "example.org.", test.exists); err != nil {
+			t.Fatal(err)
+		}
+		// The compact denial of a name that does not exist is a NODATA, RFC 9824 section 3
+		if msg.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: rcode %s", test.name, dns.RcodeToString[msg.Rcode])
+		}
+		nsec, ok := msg.Ns[len(msg.Ns)-1].(*dns.NSEC)
+		if !ok {
+			t.Fatalf("%s: no NSEC in %v", test.name, msg.Ns)
+		}
+		if !reflect.DeepEqual(nsec.TypeBitMap, test.want) {
+			t.Errorf("%s: bitmap %v, want %v", test.name, nsec.TypeBitMap, test.want)
+		}
+		if nsec.Hdr.Ttl != 300 || nsec.NextDomain != `\000.`+test.name {
+			t.Errorf("%s: NSEC %s, want the negative ttl and the immediate successor", test.name, nsec)
+		}
+	}
+}
+
+// TestCompactDenialSigned checks the negative answers of a signed zone served to a DNSSEC client carry a signed
+// NSEC of the name, and that other clients still get NXDOMAIN.
+func TestCompactDenialSigned(t *testing.T) {
+	m, keys := newSignedMysql(t)
+
+	tests := []struct {
+		name  string
+		qType uint16
+		want  []uint16
+	}{
+		{"nx.example.org.", dns.TypeA, []uint16{dns.TypeRRSIG, dns.TypeNSEC, nxnameType}},
+		{"www.example.org.", dns.TypeTXT, []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC}},
+	}
+	for _, tt := range tests {
+		msg := exchangeDO(t, m, tt.name, tt.qType)
+		if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != zero {
+			t.Errorf("%s: rcode %s answer %v, want NODATA", tt.name, dns.RcodeToString[msg.Rcode], msg.Answer)
+		}
+		var soa, nsec bool
+		for _, rr := range msg.Ns {
+			switch rr := rr.(type) {
+			case *dns.SOA:
+				soa = true
+			case *dns.NSEC:
+				nsec = true
+				if rr.Hdr.Name != tt.name || !reflect.DeepEqual(rr.TypeBitMap, tt.want) {
+					t.Errorf("%s: NSEC %s, want bitmap %v", tt.name, rr, tt.want)
+				}
+			}
+		}
+		if !soa || !nsec {
+			t.Errorf("%s: authority %v, want the SOA and an NSEC", tt.name, msg.Ns)
+		}
+		verifySection(t, "authority", msg.Ns, keys)
+	}
+
+	if msg := exchange(t, m, "nx.example.org.", dns.TypeA); msg.Rcode != dns.RcodeNameError {
+		t.Errorf("without DO got rcode %s, want NXDOMAIN", dns.RcodeToString[msg.Rcode])
+	}
+}
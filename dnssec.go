@@ -0,0 +1,301 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// zoneKey is a DNSSEC key of a signed zone, read from its BIND style key files.
+type zoneKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+	tag    uint16
+}
+
+// zoneKeys are the keys of a signed zone, the KSKs sign the DNSKEY rrset and the ZSKs every other one.
+// A zone with a single kind of key uses it for both.
+type zoneKeys struct {
+	ksks []zoneKey
+	zsks []zoneKey
+}
+
+// signatureCache keeps the RRSIGs of the rrsets already signed, by rrset and key, until they need a refresh.
+type signatureCache struct {
+	sync.Mutex
+	entries map[[sha256.Size]byte]*dns.RRSIG
+}
+
+// readZoneKey reads the key pair of base, the name of its .key or .private file with or without the extension.
+func readZoneKey(base string) (zoneKey, error) {
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".key"), ".private")
+	public, err := os.ReadFile(base + ".key")
+	if err != nil {
+		return zoneKey{}, err
+	}
+	rr, err := dns.NewRR(string(public))
+	if err != nil {
+		return zoneKey{}, err
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return zoneKey{}, fmt.Errorf("%s.key is not a DNSKEY record", base)
+	}
+
+	file, err := os.Open(base + ".private")
+	if err != nil {
+		return zoneKey{}, err
+	}
+	defer file.Close()
+	privateKey, err := dnskey.ReadPrivateKey(file, base+".private")
+	if err != nil {
+		return zoneKey{}, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return zoneKey{}, fmt.Errorf("%s.private is not a signing key", base)
+	}
+	return zoneKey{dnskey: dnskey, signer: signer, tag: dnskey.KeyTag()}, nil
+}
+
+// readZoneKeys reads the keys of zone from files, every key must belong to zone.
+func readZoneKeys(zone string, files []string) (*zoneKeys, error) {
+	keys := &zoneKeys{}
+	for _, file := range files {
+		key, err := readZoneKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(key.dnskey.Hdr.Name, zone) {
+			return nil, fmt.Errorf("key %s belongs to %s, not to %s", file, key.dnskey.Hdr.Name, zone)
+		}
+		if key.dnskey.Flags&dns.SEP != zero {
+			keys.ksks = append(keys.ksks, key)
+		} else {
+			keys.zsks = append(keys.zsks, key)
+		}
+	}
+	if len(keys.ksks) == zero {
+		keys.ksks = keys.zsks
+	}
+	if len(keys.zsks) == zero {
+		keys.zsks = keys.ksks
+	}
+	return keys, nil
+}
+
+// dnskeyRecords returns the DNSKEY rrset of the apex of zone.
+func (k *zoneKeys) dnskeyRecords(zoneID int, zone string) []record {
+	var records []record
+	seen := make(map[uint16]bool)
+	for _, key := range append(k.ksks, k.zsks...) {
+		if seen[key.tag] {
+			continue
+		}
+		seen[key.tag] = true
+		dnskey := key.dnskey
+		records = append(records, record{
+			zoneID:   zoneID,
+			name:     zoneSelf,
+			fqdn:     zone,
+			zoneName: zone,
+			qType:    dnskeyQtype,
+			data:     fmt.Sprintf("%d %d %d %s", dnskey.Flags, dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey),
+			ttl:      dnskey.Hdr.Ttl,
+		})
+	}
+	return records
+}
+
+// zoneKeys returns the keys of zone, or nil if zone is not signed.
+func (m *Mysql) zoneKeys(zone string) *zoneKeys {
+	return m.dnssecKeys[strings.ToLower(zone)]
+}
+
+// signMsg adds the RRSIGs of the rrsets of msg owned by signed zones, RFC 4035 section 3.1.
+func (m *Mysql) signMsg(ctx context.Context, msg *dns.Msg) {
+	msg.Answer = m.signRRs(ctx, msg.Answer)
+	msg.Ns = m.signRRs(ctx, msg.Ns)
+	msg.Extra = m.signRRs(ctx, msg.Extra)
+}
+
+// signRRs returns rrs with the RRSIGs of each of their rrsets following it.
+func (m *Mysql) signRRs(ctx context.Context, rrs []dns.RR) []dns.RR {
+	signed := make([]dns.RR, zero, len(rrs))
+	for _, rrset := range splitRRsets(rrs) {
+		switch rrset[zero].Header().Rrtype {
+		case dns.TypeOPT, dns.TypeRRSIG:
+			signed = append(signed, rrset...)
+			continue
+		}
+		sigs := m.signRRset(ctx, rrset)
+		signed = append(signed, rrset...)
+		signed = append(signed, sigs...)
+	}
+	return signed
+}
+
+// splitRRsets groups rrs by owner, type and class, in the order the rrsets first appear.
+func splitRRsets(rrs []dns.RR) [][]dns.RR {
+	var rrsets [][]dns.RR
+	index := make(map[string]int)
+	for _, rr := range rrs {
+		header := rr.Header()
+		key := fmt.Sprintf("%s/%d/%d", strings.ToLower(header.Name), header.Rrtype, header.Class)
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []dns.RR{rr})
+	}
+	return rrsets
+}
+
+// signRRset returns the RRSIGs of rrset by the keys of the signed zone owning it, from the signature cache
+// if they are still fresh. The rrset ttls are aligned to the lowest one, RFC 2181 section 5.2, on copies as the
+// records may be shared with the degrade cache.
+func (m *Mysql) signRRset(ctx context.Context, rrset []dns.RR) []dns.RR {
+	header := rrset[zero].Header()
+	zoneID, host, zone, ok := m.findZone(header.Name)
+	if !ok {
+		return nil
+	}
+	keys := m.zoneKeys(zone)
+	if keys == nil {
+		return nil
+	}
+	signingKeys := keys.zsks
+	if header.Rrtype == dns.TypeDNSKEY {
+		signingKeys = keys.ksks
+	}
+
+	ttl := header.Ttl
+	for _, rr := range rrset {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	for i, rr := range rrset {
+		if rr.Header().Ttl != ttl {
+			rrset[i] = dns.Copy(rr)
+			rrset[i].Header().Ttl = ttl
+		}
+	}
+
+	var (
+		sigs     []dns.RR
+		signSet  []dns.RR
+		resolved bool
+	)
+	now := time.Now()
+	for _, key := range signingKeys {
+		cacheKey := signatureCacheKey(rrset, key.tag)
+		if sig := m.signatures.get(cacheKey, now); sig != nil {
+			signatureCount.With(prometheus.Labels{"status": "cached"}).Inc()
+			sigs = append(sigs, sig)
+			continue
+		}
+		// Whether rrset is signed is only resolved once, on the first signature to make
+		if !resolved {
+			resolved = true
+			var err error
+			if signSet, err = m.signingSet(ctx, rrset, zoneID, host, zone); err != nil {
+				logger.Errorf("Failed to find whether %s is synthesized from a DNAME: %s", header.Name, err)
+				return sigs
+			}
+		}
+		if signSet == nil {
+			return sigs
+		}
+
+		inception := now.Add(-signatureInceptionSkew)
+		// The RRSIG ttl is the one of the rrset it covers, RFC 4034 section 3
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: ttl},
+			KeyTag:     key.tag,
+			SignerName: key.dnskey.Hdr.Name,
+			Algorithm:  key.dnskey.Algorithm,
+			Inception:  uint32(inception.Unix()),
+			Expiration: uint32(inception.Add(signatureValidity).Unix()),
+		}
+		if err := sig.Sign(key.signer, signSet); err != nil {
+			signatureCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			logger.Errorf("Failed to sign %s %s with key %d: %s", header.Name, dns.TypeToString[header.Rrtype], key.tag, err)
+			continue
+		}
+		signatureCount.With(prometheus.Labels{"status": "success"}).Inc()
+		m.signatures.set(cacheKey, sig)
+		sigs = append(sigs, dns.Copy(sig))
+	}
+	return sigs
+}
+
+// signingSet returns the rrset to sign for rrset, or nil if it must not be signed as the CNAME synthesized from
+// a DNAME, RFC 6672 section 5.3.1. Wildcard expansions are signed as if their owner existed, the compact denial
+// of existence answers their owner as existing too, RFC 9824 section 4.
+func (m *Mysql) signingSet(ctx context.Context, rrset []dns.RR, zoneID int, host, zone string) ([]dns.RR, error) {
+	if rrset[zero].Header().Rrtype != dns.TypeCNAME {
+		return rrset, nil
+	}
+	exists, err := m.nameExists(ctx, zoneID, host, zone)
+	if err != nil || exists {
+		return rrset, err
+	}
+	dnameRecords, err := m.findDNAME(ctx, zoneID, host, zone)
+	if err != nil || len(dnameRecords) > zero {
+		return nil, err
+	}
+	return rrset, nil
+}
+
+func signatureCacheKey(rrset []dns.RR, tag uint16) [sha256.Size]byte {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d", tag)
+	for _, rr := range rrset {
+		hash.Write([]byte(strings.ToLower(rr.String())))
+	}
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{entries: make(map[[sha256.Size]byte]*dns.RRSIG)}
+}
+
+// get returns a copy of the cached RRSIG of key, or nil if there is none to be refreshed after now.
+func (c *signatureCache) get(key [sha256.Size]byte, now time.Time) dns.RR {
+	c.Lock()
+	defer c.Unlock()
+	sig, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.Unix() >= int64(sig.Inception)+int64(signatureRefresh/time.Second) {
+		delete(c.entries, key)
+		return nil
+	}
+	return dns.Copy(sig)
+}
+
+// set caches sig, an arbitrary entry is evicted once the cache is full.
+func (c *signatureCache) set(key [sha256.Size]byte, sig *dns.RRSIG) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.entries) >= signatureCacheEntries {
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[key] = sig
+}
@@ -0,0 +1,208 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// writeTestKey generates a key of zone with flags and writes its BIND style key files to dir, it returns their
+// base name and the DNSKEY.
+func writeTestKey(t *testing.T, dir, zone string, flags uint16) (string, *dns.DNSKEY) {
+	t.Helper()
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privateKey, err := dnskey.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", zone, dnskey.Algorithm, dnskey.KeyTag()))
+	if err := os.WriteFile(base+".key", []byte(dnskey.String()+"\n"), safeMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".private", []byte(dnskey.PrivateKeyString(privateKey.(crypto.PrivateKey))), safeMode); err != nil {
+		t.Fatal(err)
+	}
+	return base, dnskey
+}
+
+// newSignedMysql returns a snapshot mode plugin serving example.org. signed by a KSK and a ZSK.
+func newSignedMysql(t *testing.T) (*Mysql, map[uint16]*dns.DNSKEY) {
+	t.Helper()
+	dir := t.TempDir()
+	kskFile, ksk := writeTestKey(t, dir, "example.org.", dns.ZONE|dns.SEP)
+	zskFile, zsk := writeTestKey(t, dir, "example.org.", dns.ZONE)
+	m := newTestMysql(t, fmt.Sprintf("snapshot\ndnssec example.org. %s %s", kskFile, zskFile))
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	for _, record := range []record{
+		{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 300},
+		{id: 2, zoneID: 1, name: zoneSelf, qType: nsQtype, data: "ns.example.org.", ttl: 300},
+		{id: 3, zoneID: 1, name: "ns", qType: "A", data: "10.0.0.53", ttl: 300},
+		{id: 4, zoneID: 1, name: "www", qType: "A", data: "10.0.0.1", ttl: 60},
+		{id: 5, zoneID: 1, name: "www", qType: "A", data: "10.0.0.2", ttl: 30},
+		{id: 6, zoneID: 1, name: "*.wild", qType: "A", data: "10.0.0.3", ttl: 60},
+		{id: 7, zoneID: 1, name: "dname", qType: dnameQtype, data: "example.net.", ttl: 60},
+	} {
+		snapshot.add(record)
+	}
+	m.swapSnapshot(snapshot)
+	return m, map[uint16]*dns.DNSKEY{ksk.KeyTag(): ksk, zsk.KeyTag(): zsk}
+}
+
+func exchangeDO(t *testing.T, m *Mysql, name string, qType uint16) *dns.Msg {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(name, qType)
+	r.SetEdns0(4096, true)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := m.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Msg
+}
+
+// verifySection checks every rrset of rrs is covered by a valid RRSIG with its ttl, the types of unsigned are
+// expected to have none. It returns the RRSIGs.
+func verifySection(t *testing.T, section string, rrs []dns.RR, keys map[uint16]*dns.DNSKEY, unsigned ...uint16) []*dns.RRSIG {
+	t.Helper()
+	var sigs []*dns.RRSIG
+	for _, rrset := range splitRRsets(rrs) {
+		header := rrset[zero].Header()
+		if header.Rrtype == dns.TypeOPT || header.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		var covering []*dns.RRSIG
+		for _, rr := range rrs {
+			if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == header.Rrtype && dns.CanonicalName(sig.Hdr.Name) == dns.CanonicalName(header.Name) {
+				covering = append(covering, sig)
+			}
+		}
+		name := fmt.Sprintf("%s %s %s", section, header.Name, dns.TypeToString[header.Rrtype])
+		skip := false
+		for _, qType := range unsigned {
+			skip = skip || qType == header.Rrtype
+		}
+		if skip {
+			if len(covering) > zero {
+				t.Errorf("%s: signed, want no RRSIG", name)
+			}
+			continue
+		}
+		if len(covering) == zero {
+			t.Errorf("%s: no RRSIG", name)
+		}
+		for _, sig := range covering {
+			key, ok := keys[sig.KeyTag]
+			if !ok {
+				t.Errorf("%s: signed by unknown key %d", name, sig.KeyTag)
+				continue
+			}
+			if err := sig.Verify(key, rrset); err != nil {
+				t.Errorf("%s: RRSIG by key %d does not verify: %s", name, sig.KeyTag, err)
+			}
+			if !sig.ValidityPeriod(time.Now()) {
+				t.Errorf("%s: RRSIG not valid now", name)
+			}
+			if sig.Hdr.Ttl != header.Ttl {
+				t.Errorf("%s: RRSIG ttl %d, want the rrset ttl %d", name, sig.Hdr.Ttl, header.Ttl)
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+func TestSignMsg(t *testing.T) {
+	m, keys := newSignedMysql(t)
+
+	tests := []struct {
+		name     string
+		qType    uint16
+		unsigned []uint16
+	}{
+		// The answer rrset, the NS rrset in authority and the address of the name server in additional
+		{name: "www.example.org.", qType: dns.TypeA},
+		{name: "a.wild.example.org.", qType: dns.TypeA},
+		{name: "example.org.", qType: dns.TypeDNSKEY},
+		// The CNAME synthesized from a DNAME is not signed, RFC 6672 section 5.3.1
+		{name: "x.dname.example.org.", qType: dns.TypeA, unsigned: []uint16{dns.TypeCNAME}},
+	}
+	for _, tt := range tests {
+		msg := exchangeDO(t, m, tt.name, tt.qType)
+		if len(msg.Answer) == zero {
+			t.Fatalf("%s: no answer", tt.name)
+		}
+		verifySection(t, "answer", msg.Answer, keys, tt.unsigned...)
+		verifySection(t, "authority", msg.Ns, keys)
+		verifySection(t, "additional", msg.Extra, keys)
+	}
+
+	// The wildcard expansion is signed as if its owner existed
+	msg := exchangeDO(t, m, "a.wild.example.org.", dns.TypeA)
+	for _, sig := range verifySection(t, "answer", msg.Answer, keys) {
+		if int(sig.Labels) != dns.CountLabel("a.wild.example.org.") {
+			t.Errorf("wildcard RRSIG labels %d", sig.Labels)
+		}
+	}
+	// The DNSKEY rrset is signed by the KSK only
+	msg = exchangeDO(t, m, "example.org.", dns.TypeDNSKEY)
+	for _, sig := range verifySection(t, "answer", msg.Answer, keys) {
+		if keys[sig.KeyTag].Flags&dns.SEP == zero {
+			t.Errorf("DNSKEY rrset signed by the ZSK %d", sig.KeyTag)
+		}
+	}
+	// Without DO nothing is signed
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m.ServeDNS(context.Background(), rec, r)
+	for _, rr := range append(rec.Msg.Answer, rec.Msg.Ns...) {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			t.Errorf("signed answer without DO: %s", rr)
+		}
+	}
+}
+
+func TestSignatureCache(t *testing.T) {
+	m, keys := newSignedMysql(t)
+	first := verifySection(t, "answer", exchangeDO(t, m, "www.example.org.", dns.TypeA).Answer, keys)
+	second := verifySection(t, "answer", exchangeDO(t, m, "www.example.org.", dns.TypeA).Answer, keys)
+	// ECDSA signatures are randomized, the same one twice comes from the cache
+	if len(first) != 1 || len(second) != 1 || first[zero].Signature != second[zero].Signature {
+		t.Fatalf("got RRSIGs %v then %v, want the cached one", first, second)
+	}
+
+	rrset := exchangeDO(t, m, "www.example.org.", dns.TypeA).Answer[:2]
+	key := signatureCacheKey(rrset, first[zero].KeyTag)
+	inception := time.Unix(int64(first[zero].Inception), zero)
+	if m.signatures.get(key, inception.Add(signatureRefresh-time.Second)) == nil {
+		t.Error("RRSIG evicted before its refresh time")
+	}
+	if m.signatures.get(key, inception.Add(signatureRefresh)) != nil {
+		t.Error("RRSIG still cached at its refresh time")
+	}
+	third := verifySection(t, "answer", exchangeDO(t, m, "www.example.org.", dns.TypeA).Answer, keys)
+	if len(third) != 1 || third[zero].Signature == first[zero].Signature {
+		t.Errorf("got RRSIG %v, want a new signature once refreshed", third)
+	}
+}
+
+func TestTransferSigned(t *testing.T) {
+	m, _ := newSignedMysql(t)
+	if _, err := m.Transfer("example.org.", 0); !errors.Is(err, errSignedTransfer) {
+		t.Errorf("transfer of a signed zone got error %v, want %v", err, errSignedTransfer)
+	}
+}
@@ -0,0 +1,275 @@
+package coredns_mysql_extend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// dumpEnvelope is the layout of dump_file, the checksum is the sha256 of the compacted payload.
+// Version 1 carries the legacy records, version 2 carries the content.
+type dumpEnvelope struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Records  json.RawMessage `json:"records,omitempty"`
+	Content  json.RawMessage `json:"content,omitempty"`
+}
+
+// dumpContent is the payload of a version 2 dump file.
+type dumpContent struct {
+	Hostname  string      `json:"hostname"`
+	Source    string      `json:"source"`
+	CreatedAt time.Time   `json:"created_at"`
+	Zones     []dumpZone  `json:"zones"`
+	Entries   []dumpEntry `json:"entries"`
+	// Records are only saved for the cold start
+	Records []dumpRecord `json:"records,omitempty"`
+}
+
+type dumpZone struct {
+	Name string `json:"name"`
+	ID   int    `json:"id"`
+	// Serial is left out when the SOA of the zone was not seen
+	Serial *uint32 `json:"serial,omitempty"`
+	TTL    uint32  `json:"ttl,omitempty"`
+}
+
+type dumpRecord struct {
+	ID     int    `json:"id"`
+	ZoneID int    `json:"zone_id"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Data   string `json:"data"`
+	TTL    uint32 `json:"ttl"`
+}
+
+type dumpEntry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	RRs      []string  `json:"rrs"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// dumpGenerationFile returns the name of a dump generation, 0 is the latest one.
+func dumpGenerationFile(path string, generation int) string {
+	if generation == zero {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, generation)
+}
+
+// writeDumpFile replaces path with content through a synced temporary file, the previous
+// generations are kept as path.1 ... path.N-1.
+func writeDumpFile(path string, content dumpContent, compression string, generations int) error {
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(dumpEnvelope{Version: dumpFormatVersion, Checksum: dumpChecksum(raw), Content: raw})
+	if err != nil {
+		return err
+	}
+	if data, err = compressDump(data, compression); err != nil {
+		return err
+	}
+
+	temp := path + dumpTempSuffix
+	if err := writeSyncFile(temp, data); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	// Shift the older generations, the oldest one is overwritten
+	for i := generations - 1; i > zero; i-- {
+		err := os.Rename(dumpGenerationFile(path, i-1), dumpGenerationFile(path, i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warningf("Failed to rotate dump file: %s", err)
+		}
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func writeSyncFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, safeMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir persists the renames done in dir.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// readDumpFile reads and verifies one dump file of any version, compressed or not.
+func readDumpFile(path string) (dumpContent, error) {
+	var content dumpContent
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return content, err
+	}
+	if data, err = decompressDump(data); err != nil {
+		return content, err
+	}
+	data = bytes.TrimSpace(data)
+	// The legacy layout is a plain array without any envelope
+	if bytes.HasPrefix(data, []byte("[")) {
+		return legacyDumpContent(data, path)
+	}
+
+	var envelope dumpEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return content, err
+	}
+	payload := envelope.Content
+	switch envelope.Version {
+	case 1:
+		payload = envelope.Records
+	case dumpFormatVersion:
+	default:
+		return content, fmt.Errorf("unsupported dump format version %d", envelope.Version)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, payload); err != nil {
+		return content, err
+	}
+	if checksum := dumpChecksum(compact.Bytes()); checksum != envelope.Checksum {
+		return content, fmt.Errorf("checksum mismatch, want %s, got %s", envelope.Checksum, checksum)
+	}
+	if envelope.Version == 1 {
+		return legacyDumpContent(payload, path)
+	}
+	err = json.Unmarshal(payload, &content)
+	return content, err
+}
+
+// legacyDumpContent converts the "fqdn:qtype" -> rrStrings records of the older versions.
+func legacyDumpContent(data []byte, path string) (dumpContent, error) {
+	var (
+		content     dumpContent
+		pureRecords []pureRecord
+	)
+	if err := json.Unmarshal(data, &pureRecords); err != nil {
+		return content, err
+	}
+	// Legacy records have no last seen time, take the time they were written
+	lastSeen := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		lastSeen = info.ModTime()
+	}
+	for _, rMap := range pureRecords {
+		for queryKey, rrStrings := range rMap {
+			i := strings.LastIndex(queryKey, keySeparator)
+			if i < zero {
+				continue
+			}
+			content.Entries = append(content.Entries, dumpEntry{Name: queryKey[:i], Type: queryKey[i+1:], RRs: rrStrings, LastSeen: lastSeen})
+		}
+	}
+	return content, nil
+}
+
+// loadDumpFile returns the content of the latest good generation of path and its file name.
+func loadDumpFile(path string, generations int) (dumpContent, string, error) {
+	var lastErr error
+	for i := zero; i < generations; i++ {
+		file := dumpGenerationFile(path, i)
+		content, err := readDumpFile(file)
+		if err == nil {
+			return content, file, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warningf("Skip bad dump file %s: %s", file, err)
+		}
+		if lastErr == nil || !errors.Is(err, os.ErrNotExist) {
+			lastErr = err
+		}
+	}
+	return dumpContent{}, "", lastErr
+}
+
+func compressDump(data []byte, compression string) ([]byte, error) {
+	var buffer bytes.Buffer
+	switch compression {
+	case gzipCompression:
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case zstdCompression:
+		writer, err := zstd.NewWriter(&buffer)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+	return buffer.Bytes(), nil
+}
+
+// decompressDump detects the compression of data by its magic number.
+func decompressDump(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case bytes.HasPrefix(data, zstdMagic):
+		reader, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return data, nil
+}
+
+func dumpChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
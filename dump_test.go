@@ -0,0 +1,113 @@
+package coredns_mysql_extend
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDumpContent(hostname string) dumpContent {
+	return dumpContent{
+		Hostname:  hostname,
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Entries: []dumpEntry{{
+			Name:     "www.example.org.",
+			Type:     "A",
+			RRs:      []string{"www.example.org.\t60\tIN\tA\t10.0.0.1"},
+			LastSeen: time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC),
+		}},
+	}
+}
+
+func TestWriteDumpFileCompression(t *testing.T) {
+	tests := []struct {
+		compression string
+		magic       []byte
+	}{
+		{noneCompression, []byte("{")},
+		{gzipCompression, gzipMagic},
+		{zstdCompression, zstdMagic},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "dump.json")
+		want := testDumpContent(test.compression)
+		if err := writeDumpFile(path, want, test.compression, 1); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, test.magic) {
+			t.Errorf("%s: dump starts with %q", test.compression, data[:4])
+		}
+		got, err := readDumpFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", test.compression, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: read %+v, want %+v", test.compression, got, want)
+		}
+	}
+}
+
+// TestLoadDumpFileGenerations checks a dump file failing its checksum or truncated falls back to the previous
+// generation.
+func TestLoadDumpFileGenerations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.json")
+	for _, hostname := range []string{"first", "second", "third"} {
+		if err := writeDumpFile(path, testDumpContent(hostname), noneCompression, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(dumpGenerationFile(path, 2)); !os.IsNotExist(err) {
+		t.Errorf("generation 2 kept with 2 generations: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	tampered := bytes.Replace(data, []byte("10.0.0.1"), []byte("10.6.6.6"), 1)
+	if err := os.WriteFile(path, tampered, safeMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDumpFile(path); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("read of a tampered dump got error %v, want a checksum mismatch", err)
+	}
+	content, file, err := loadDumpFile(path, 2)
+	if err != nil || file != dumpGenerationFile(path, 1) || content.Hostname != "second" {
+		t.Errorf("loaded %s of %s with error %v, want the second dump from %s", content.Hostname, file, err, dumpGenerationFile(path, 1))
+	}
+
+	if err := os.WriteFile(dumpGenerationFile(path, 1), data[:len(data)/2], safeMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadDumpFile(path, 2); err == nil {
+		t.Error("loaded a dump while every generation is bad")
+	}
+}
+
+func TestReadLegacyDumpFile(t *testing.T) {
+	legacy := []byte(`[{"www.example.org.:A":["www.example.org. 60 IN A 10.0.0.1"]}]`)
+	var compact bytes.Buffer
+	json.Compact(&compact, legacy)
+	version1, _ := json.Marshal(dumpEnvelope{Version: 1, Checksum: dumpChecksum(compact.Bytes()), Records: legacy})
+
+	for name, data := range map[string][]byte{"plain array": legacy, "version 1": version1} {
+		path := filepath.Join(t.TempDir(), "dump.json")
+		if err := os.WriteFile(path, data, safeMode); err != nil {
+			t.Fatal(err)
+		}
+		content, err := readDumpFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(content.Entries) != 1 || content.Entries[zero].Name != "www.example.org." || content.Entries[zero].Type != "A" ||
+			!reflect.DeepEqual(content.Entries[zero].RRs, []string{"www.example.org. 60 IN A 10.0.0.1"}) {
+			t.Errorf("%s: read entries %+v", name, content.Entries)
+		}
+	}
+}
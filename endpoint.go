@@ -0,0 +1,217 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// endpoint is one database configured by a dsn directive.
+type endpoint struct {
+	dsn      string
+	role     string
+	priority int
+}
+
+// endpointState is the connection and health of an endpoint.
+type endpointState struct {
+	endpoint
+	name    string
+	backend Backend
+	healthy bool
+}
+
+// parseEndpoint parses the arguments of a dsn directive: DSN [ROLE [PRIORITY]].
+func parseEndpoint(args []string) (endpoint, error) {
+	e := endpoint{role: primaryRole}
+	switch len(args) {
+	case 3:
+		priority, err := strconv.Atoi(args[2])
+		if err != nil {
+			return e, fmt.Errorf("invalid dsn priority '%s'", args[2])
+		}
+		e.priority = priority
+		fallthrough
+	case 2:
+		if args[1] != primaryRole && args[1] != replicaRole {
+			return e, fmt.Errorf("invalid dsn role '%s', must be %s or %s", args[1], primaryRole, replicaRole)
+		}
+		e.role = args[1]
+		fallthrough
+	case 1:
+		e.dsn = args[0]
+	default:
+		return e, fmt.Errorf("dsn needs 1 to 3 arguments")
+	}
+	return e, nil
+}
+
+// dsnPassword matches the password of a key/value dsn, as "host=localhost password='se cret'" of postgres.
+var dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// endpointName hides the credentials of dsn so it can be logged and used as a metric label.
+func endpointName(dsn string) string {
+	name := dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+	scheme := ""
+	if i := strings.Index(name, "://"); i >= zero {
+		scheme, name = name[:i+3], name[i+3:]
+	}
+	// The userinfo ends at the last @ before the database name, the password may hold an @ or a /
+	path := strings.LastIndex(name, "/")
+	if path < zero {
+		path = len(name)
+	}
+	if i := strings.LastIndex(name[:path], "@"); i >= zero {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "?"); i >= zero {
+		name = name[:i]
+	}
+	return scheme + name
+}
+
+// initEndpoints orders the endpoints by preference, replicas first, then by priority.
+func (m *Mysql) initEndpoints() {
+	endpoints := m.endpoints
+	if len(endpoints) == zero {
+		endpoints = []endpoint{{dsn: defaultDSN, role: primaryRole}}
+	}
+	m.endpointStates = make([]*endpointState, zero, len(endpoints))
+	for _, e := range endpoints {
+		m.endpointStates = append(m.endpointStates, &endpointState{endpoint: e, name: endpointName(e.dsn)})
+	}
+	sort.SliceStable(m.endpointStates, func(i, j int) bool {
+		a, b := m.endpointStates[i], m.endpointStates[j]
+		if a.role != b.role {
+			return a.role == replicaRole
+		}
+		return a.priority < b.priority
+	})
+}
+
+// openEndpoints opens every endpoint and makes the most preferred one active, without waiting for a ping.
+func (m *Mysql) openEndpoints() {
+	for _, state := range m.endpointStates {
+		backend, err := m.openBackend(state.dsn)
+		if err != nil {
+			continue
+		}
+		m.backendLock.Lock()
+		state.backend = backend
+		m.backendLock.Unlock()
+		if m.activeEndpoint == nil {
+			m.activate(state)
+		}
+	}
+}
+
+// primaryBackend returns the backend of the most preferred primary, or the active one if no primary is open.
+func (m *Mysql) primaryBackend() Backend {
+	m.backendLock.RLock()
+	defer m.backendLock.RUnlock()
+	for _, state := range m.endpointStates {
+		if state.role == primaryRole && state.backend != nil {
+			return state.backend
+		}
+	}
+	return m.backend
+}
+
+func (m *Mysql) closeEndpoints() {
+	for _, state := range m.endpointStates {
+		if state.backend != nil {
+			state.backend.Close()
+		}
+	}
+}
+
+// checkEndpoints pings every endpoint, reopens the failed ones and activates the most preferred healthy one.
+// It returns true if the most preferred endpoint is the active one.
+func (m *Mysql) checkEndpoints() bool {
+	var active *endpointState
+	for _, state := range m.endpointStates {
+		err := errBackendNotReady
+		if state.backend != nil {
+			ctx, cancel := context.WithTimeout(m.ctx, m.queryTimeout)
+			err = state.backend.Ping(ctx)
+			cancel()
+		}
+		if err != nil {
+			logger.Errorf("Failed to ping database %s: %s", state.name, err)
+			dbPingCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			m.setEndpointHealth(state, false)
+
+			if state.backend != nil {
+				state.backend.Close()
+			}
+			backend, err := m.openBackend(state.dsn)
+			if err != nil {
+				backend = nil
+			}
+			m.backendLock.Lock()
+			state.backend = backend
+			// Until another endpoint is healthy, keep the reopened one active
+			if state == m.activeEndpoint {
+				m.backend = backend
+			}
+			m.backendLock.Unlock()
+			continue
+		}
+		logger.Debugf("Success to ping database %s", state.name)
+		dbPingCount.With(prometheus.Labels{"status": "success"}).Inc()
+		m.setEndpointHealth(state, true)
+		if active == nil {
+			active = state
+		}
+	}
+
+	if active == nil {
+		return false
+	}
+	if active != m.activeEndpoint {
+		m.activate(active)
+	}
+	return active == m.endpointStates[zero]
+}
+
+func (m *Mysql) activate(state *endpointState) {
+	logger.Infof("Use %s database %s, priority %d", state.role, state.name, state.priority)
+	for _, other := range m.endpointStates {
+		value := float64(zero)
+		if other == state {
+			value = 1
+		}
+		endpointActiveGauge.With(prometheus.Labels{"endpoint": other.name, "role": other.role}).Set(value)
+	}
+	m.backendLock.Lock()
+	defer m.backendLock.Unlock()
+	m.backend = state.backend
+	m.activeEndpoint = state
+}
+
+func (m *Mysql) setEndpointHealth(state *endpointState, healthy bool) {
+	state.healthy = healthy
+	value := float64(zero)
+	if healthy {
+		value = 1
+	}
+	endpointUpGauge.With(prometheus.Labels{"endpoint": state.name, "role": state.role}).Set(value)
+}
+
+func (m *Mysql) rePing() {
+	for {
+		// Keep checking fast until the most preferred endpoint is back, so lookups fail back to it
+		heartbeatTime := m.successHeartbeatTime
+		if !m.checkEndpoints() {
+			heartbeatTime = m.failHeartbeatTime
+		}
+		if !m.sleep(heartbeatTime) {
+			return
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package coredns_mysql_extend
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
+	"context"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,162 +11,230 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-func (m *Mysql) rePing() {
-	for {
-		if err := m.db.Ping(); err != nil {
-			time.Sleep(m.failHeartbeatTime)
-			m.db.Close()
-			newDB, err := m.openDB()
-			if err == nil {
-				m.db = newDB
-			}
-			logger.Errorf("Failed to ping database: %s", err)
-			dbPingCount.With(prometheus.Labels{"status": "fail"}).Inc()
-			continue
-		}
-		time.Sleep(m.successHeartbeatTime)
-
-		logger.Debug("Success to ping database")
-		dbPingCount.With(prometheus.Labels{"status": "success"}).Inc()
-	}
-}
-
 func (m *Mysql) reGetZone() {
 	for {
-		zoneMap := make(map[string]int, 0)
-		rows, err := m.db.Query(m.queryZoneSQL)
+		zoneMap, zoneTTLs, err := m.queryZones()
 		if err != nil {
 			logger.Errorf("Failed to query zones: %s", err)
 			dbGetZoneCount.With(prometheus.Labels{"status": "fail"}).Inc()
 
-			time.Sleep(m.failHeartbeatTime)
+			if !m.sleep(m.failHeartbeatTime) {
+				return
+			}
 			continue
 		}
 
-		for rows.Next() {
-			var zoneRecord zoneRecord
-			err := rows.Scan(&zoneRecord.id, &zoneRecord.name)
-			if err != nil {
-				logger.Error(err)
-			}
-			zoneMap[zoneRecord.name] = zoneRecord.id
+		m.setZoneMap(zoneMap, zoneTTLs)
+		m.leaveColdStart()
+		m.notifyZones(zoneMap)
+		if m.coldStartMode {
+			m.refreshColdStart()
 		}
-		m.zoneMap = zoneMap
 		logger.Debugf("Success to query zones: %#v", zoneMap)
 		dbGetZoneCount.With(prometheus.Labels{"status": "success"}).Inc()
 
-		time.Sleep(m.successHeartbeatTime)
+		if !m.sleep(m.successHeartbeatTime) {
+			return
+		}
 	}
 }
 
-func (m *Mysql) loadLocalData() {
-	cache := make(map[record]dnsRecordInfo, zero)
-	m.degradeCache = cache
-	pureRecords := make([]pureRecord, zero)
-	content, err := os.ReadFile(m.dumpFile)
+func (m *Mysql) queryZones() (map[string]int, map[int]uint32, error) {
+	backend, err := m.getBackend()
 	if err != nil {
-		logger.Errorf("Failed to load data from file: %s", err)
-		loadLocalData.With(prometheus.Labels{"status": "fail"}).Inc()
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, m.queryTimeout)
+	defer cancel()
+	return backend.ListZones(ctx)
+}
 
-		return
+// reDump dumps the degrade data every dump interval, so it survives a crash.
+func (m *Mysql) reDump() {
+	for m.sleep(m.dumpInterval) {
+		m.dump2LocalData()
+	}
+}
+
+// startWorker runs worker in a background goroutine tracked until shutdown.
+func (m *Mysql) startWorker(worker func()) {
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		worker()
+	}()
+}
+
+// sleep waits for d, it returns false if the plugin is shut down meanwhile.
+func (m *Mysql) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-m.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
-	err = json.Unmarshal(content, &pureRecords)
+}
+
+func (m *Mysql) loadLocalData() {
+	content, file, err := loadDumpFile(m.dumpFile, m.dumpGenerations)
 	if err != nil {
 		logger.Errorf("Failed to load data from file: %s", err)
 		loadLocalData.With(prometheus.Labels{"status": "fail"}).Inc()
 
 		return
 	}
+	if file != m.dumpFile {
+		logger.Warningf("Load data from older dump file %s", file)
+	}
 
-	for _, rMap := range pureRecords {
-		for queryKey, rrStrings := range rMap {
-			var response []dns.RR
-			queryKeySlice := strings.Split(queryKey, keySeparator)
-			fqdn, qType := queryKeySlice[0], queryKeySlice[1]
-			record := record{fqdn: fqdn, qType: qType}
-			for _, rrString := range rrStrings {
-				rr, err := dns.NewRR(rrString)
-				if err != nil {
-					continue
-				}
-				response = append(response, rr)
+	for _, entry := range content.Entries {
+		var response []dns.RR
+		record := record{fqdn: entry.Name, qType: entry.Type}
+		for _, rrString := range entry.RRs {
+			rr, err := dns.NewRR(rrString)
+			if err != nil {
+				continue
 			}
-			dnsRecordInfo := dnsRecordInfo{rrStrings: rrStrings, response: response}
-			cache[record] = dnsRecordInfo
+			response = append(response, rr)
 		}
+		dnsRecordInfo := dnsRecordInfo{rrStrings: entry.RRs, response: response}
+		m.degradeCache.restore(record, dnsRecordInfo, entry.LastSeen)
+	}
+	if m.coldStartMode {
+		m.coldStart(content)
 	}
-	// TODO add lock
-	logger.Debugf("Load degrade data from local file %#v", cache)
+	logger.Infof("Load %d degrade records of %d zones from %s, written by %s at %s", len(content.Entries), len(content.Zones),
+		file, content.Hostname, content.CreatedAt.Format(time.RFC3339))
 	loadLocalData.With(prometheus.Labels{"status": "success"}).Inc()
-	m.degradeCache = cache
 }
 
 func (m *Mysql) dump2LocalData() {
-	pureRecord := make([]pureRecord, zero)
-	for record, dnsRecordInfo := range m.degradeCache {
-		logger.Debugf("Record %#v", record)
-		pureRecord = append(pureRecord, map[string][]string{
-			fmt.Sprintf("%s%s%s", record.fqdn, keySeparator, record.qType): dnsRecordInfo.rrStrings,
-		})
+	// The shut down dump may run while the reDump loop is still dumping
+	m.dumpLock.Lock()
+	defer m.dumpLock.Unlock()
+
+	content := dumpContent{CreatedAt: time.Now()}
+	content.Hostname, _ = os.Hostname()
+	m.backendLock.RLock()
+	if m.activeEndpoint != nil {
+		content.Source = m.activeEndpoint.name
 	}
+	m.backendLock.RUnlock()
 
-	content, err := json.Marshal(pureRecord)
-	if err != nil {
-		logger.Errorf("Failed to dump data to local: %s", err)
-		dumpLocalData.With(prometheus.Labels{"status": "fail"}).Inc()
-		return
+	// In snapshot mode the dump is a serialization of the snapshot
+	if snapshot := m.snapshot.Load(); m.snapshotMode && snapshot != nil {
+		for record, dnsRecordInfo := range snapshot.degradeCache(m.recordTTL) {
+			content.Entries = append(content.Entries, dumpEntry{Name: record.fqdn, Type: record.qType, RRs: dnsRecordInfo.rrStrings, LastSeen: content.CreatedAt})
+		}
+	} else {
+		for _, entry := range m.degradeCache.entries() {
+			content.Entries = append(content.Entries, dumpEntry{Name: entry.key.fqdn, Type: entry.key.qType, RRs: entry.info.rrStrings, LastSeen: entry.updated})
+		}
+	}
+	var coldStartSnapshot *zoneSnapshot
+	if m.coldStartMode {
+		if coldStartSnapshot = m.coldStartSnapshot(); coldStartSnapshot != nil {
+			content.Records = coldStartSnapshot.dumpRecords()
+		}
 	}
-	if err := os.WriteFile(m.dumpFile, content, safeMode); err != nil {
-		logger.Error(err)
+	content.Zones = m.dumpZones(content.Entries, coldStartSnapshot)
+
+	if err := writeDumpFile(m.dumpFile, content, m.dumpCompression, m.dumpGenerations); err != nil {
 		logger.Errorf("Failed to dump data to local: %s", err)
 		dumpLocalData.With(prometheus.Labels{"status": "fail"}).Inc()
 		return
 	}
-	logger.Debugf("Success to dump data to local: %#v", pureRecord)
+	logger.Debugf("Success to dump %d records of %d zones to local", len(content.Entries), len(content.Zones))
 	dumpLocalData.With(prometheus.Labels{"status": "success"}).Inc()
 }
 
-func (m *Mysql) openDB() (*sql.DB, error) {
-	db, err := sql.Open("mysql", m.dsn)
-	if err != nil {
-		openMysqlCount.With(prometheus.Labels{"status": "fail"}).Inc()
-		logger.Errorf("Failed to open database: %s", err)
-	} else {
-		// Config db connection pool
-		db.SetConnMaxIdleTime(m.connMaxIdleTime)
-		db.SetConnMaxLifetime(m.connMaxLifetime)
-		db.SetMaxIdleConns(m.maxIdleConns)
-		db.SetMaxOpenConns(m.maxOpenConns)
-		openMysqlCount.With(prometheus.Labels{"status": "success"}).Inc()
-		logger.Debug("Success to open database")
-	}
-	return db, err
+// dumpZones lists the known zones with the serial of their SOA record found in entries, read by the notify loop
+// or in snapshot. The zones of snapshot are listed if it is not nil, so their ids match the saved records.
+func (m *Mysql) dumpZones(entries []dumpEntry, snapshot *zoneSnapshot) []dumpZone {
+	serials := make(map[string]uint32)
+	for _, entry := range entries {
+		if entry.Type != soaQtype || len(entry.RRs) == zero {
+			continue
+		}
+		if rr, err := dns.NewRR(entry.RRs[0]); err == nil && rr != nil {
+			if soa, ok := rr.(*dns.SOA); ok {
+				serials[strings.ToLower(entry.Name)] = soa.Serial
+			}
+		}
+	}
+	m.notifier.Lock()
+	for zone, state := range m.notifier.zones {
+		serials[zone] = state.serial
+	}
+	m.notifier.Unlock()
+	zoneMap, zoneTTLs := m.zoneMap.Load(), m.zoneTTLs.Load()
+	if snapshot != nil {
+		zoneMap, zoneTTLs = &snapshot.zoneMap, &snapshot.zoneTTLs
+		for name, id := range snapshot.zoneMap {
+			if serial, ok := snapshot.serial(id); ok {
+				serials[strings.ToLower(name)] = serial
+			}
+		}
+	}
+	var zones []dumpZone
+	if zoneMap != nil {
+		for name, id := range *zoneMap {
+			zone := dumpZone{Name: name, ID: id}
+			if serial, ok := serials[strings.ToLower(name)]; ok {
+				zone.Serial = &serial
+			}
+			if zoneTTLs != nil {
+				zone.TTL = (*zoneTTLs)[id]
+			}
+			zones = append(zones, zone)
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	return zones
 }
 
 func (m *Mysql) onStartup() error {
 	logger.Debug("On start up")
-	// Initialize database connection pool
-	db, _ := m.openDB()
-
-	m.db = db
-
-	// Start rePing loop
-	go m.rePing()
-	// start reGetZone loop
-	go m.reGetZone()
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	// Initialize database connection pool of every endpoint
+	m.openEndpoints()
 	// Load local file data
 	m.loadLocalData()
-	// Create tables
-	m.createTables()
+	// Create tables, replicas are read only
+	if backend := m.primaryBackend(); backend != nil {
+		ctx, cancel := context.WithTimeout(m.ctx, m.queryTimeout)
+		backend.CreateSchema(ctx)
+		cancel()
+	}
+
+	// Start rePing loop
+	m.startWorker(m.rePing)
+	// Start reLoadSnapshot loop in snapshot mode, otherwise start reGetZone loop
+	if m.snapshotMode {
+		m.startWorker(m.reLoadSnapshot)
+	} else {
+		m.startWorker(m.reGetZone)
+	}
+	// Start reDump loop
+	if m.dumpInterval > zeroTime {
+		m.startWorker(m.reDump)
+	}
 	return nil
 }
 
 func (m *Mysql) onShutdown() error {
 	logger.Debug("on shutdown")
-	if m.db != nil {
-		m.db.Close()
-	}
-	// Dump memory data to local file
+	// Dump memory data to local file, before the connections are closed as the cold start records are loaded from them
 	m.dump2LocalData()
+	// Stop the background loops and cancel their running queries, wait for them before closing the connections
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.workers.Wait()
+	m.closeEndpoints()
+	// A reload starts a new plugin with its own cache
+	m.degradeCache.release()
 	return nil
 }
@@ -0,0 +1,105 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// TestConcurrentShutdown runs queries, degrade cache writes and zone map swaps while the plugin shuts down, it is
+// meant to run with -race. No goroutine may be left once onShutdown returned.
+func TestConcurrentShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s/dns.db\nsuccess_heartbeat_time 5ms\nfail_heartbeat_time 5ms\ndump_interval 5ms", t.TempDir()))
+	if err := m.onStartup(); err != nil {
+		t.Fatal(err)
+	}
+	db := m.primaryBackend().(*sqliteBackend).db
+	for _, statement := range []string{
+		"INSERT INTO zones(id, zone_name) VALUES(1, 'example.org.')",
+		"INSERT INTO records(id, zone_id, hostname, type, data, ttl, online) VALUES(1, 1, 'www', 'A', '10.0.0.1', 60, 1)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+					f(i)
+				}
+			}
+		}()
+	}
+	for n := 0; n < 4; n++ {
+		run(func(i int) {
+			r := new(dns.Msg)
+			r.SetQuestion(fmt.Sprintf("host%d.example.org.", i%3), dns.TypeA)
+			if i%2 == zero {
+				r.SetQuestion("www.example.org.", dns.TypeA)
+			}
+			m.ServeDNS(context.Background(), &test.ResponseWriter{}, r)
+		})
+	}
+	run(func(i int) {
+		rr, _ := dns.NewRR(fmt.Sprintf("host%d.example.org. 60 IN A 10.0.0.2", i%100))
+		m.degradeWrite(record{fqdn: rr.Header().Name, qType: "A"}, dnsRecordInfo{rrStrings: []string{rr.String()}, response: []dns.RR{rr}})
+	})
+	run(func(i int) {
+		m.setZoneMap(map[string]int{"example.org.": 1}, map[int]uint32{1: uint32(i)})
+	})
+
+	time.Sleep(time.Millisecond * 50)
+	done := make(chan error)
+	go func() { done <- m.onShutdown() }()
+	time.Sleep(time.Millisecond * 20)
+	close(stop)
+	wg.Wait()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left after shutdown, %d before start up\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// TestDumpZones checks the serial of a zone is only dumped when its SOA was seen.
+func TestDumpZones(t *testing.T) {
+	m := newTestMysql(t, "")
+	m.setZoneMap(map[string]int{"example.org.": 1, "example.net.": 2, "example.com.": 3}, map[int]uint32{3: 120})
+	m.zoneChanged("example.net.", &dns.SOA{Serial: 9}, [sha256.Size]byte{})
+	entries := []dumpEntry{{Name: "example.org.", Type: soaQtype, RRs: []string{"example.org. 60 IN SOA ns.example.org. h.example.org. 7 3600 600 86400 300"}}}
+
+	seven, nine := uint32(7), uint32(9)
+	want := []dumpZone{
+		{Name: "example.com.", ID: 3, TTL: 120},
+		{Name: "example.net.", ID: 2, Serial: &nine},
+		{Name: "example.org.", ID: 1, Serial: &seven},
+	}
+	if got := m.dumpZones(entries, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got zones %+v, want %+v", got, want)
+	}
+}
@@ -2,10 +2,12 @@ package coredns_mysql_extend
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
-	"github.com/prometheus/client_golang/prometheus"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/miekg/dns"
 )
 
 func (m *Mysql) Name() string {
@@ -14,7 +16,7 @@ func (m *Mysql) Name() string {
 
 func (m *Mysql) parseConfig(c *caddy.Controller) error {
 	mysqlConfig := &mysqlConfig{
-		dsn:          defaultDSN,
+		driver:       defaultDriver,
 		dumpFile:     defaultDumpFile,
 		ttl:          defaultTTL,
 		zonesTable:   defaultZonesTable,
@@ -27,19 +29,44 @@ func (m *Mysql) parseConfig(c *caddy.Controller) error {
 
 		failHeartbeatTime:    defaultFailHeartBeatTime,
 		successHeartbeatTime: defaultSuccessHeartBeatTime,
+		changeHeartbeatTime:  defaultChangeHeartBeatTime,
+		queryTimeout:         defaultQueryTimeout,
+		degradeMaxEntries:    defaultDegradeMaxEntries,
+		dumpInterval:         defaultDumpInterval,
+		dumpGenerations:      defaultDumpGenerations,
+		dumpCompression:      noneCompression,
+		cnameMaxDepth:        defaultCNAMEMaxDepth,
+		cnameExternal:        clientExternal,
+		anyResponse:          minimalAnyResponse,
 		queryZoneSQL:         defaultQueryZoneSQL,
 		queryRecordSQL:       defaultQueryRecordSQL,
+		queryHostSQL:         defaultQueryHostSQL,
+		queryNameSQL:         defaultQueryNameSQL,
+		updateRecordSQL:      defaultUpdateRecordSQL,
+		queryAllRecordSQL:    defaultQueryAllRecordSQL,
+		queryZoneRecordSQL:   defaultQueryZoneRecordSQL,
 	}
 
 	m.mysqlConfig = mysqlConfig
 	for c.Next() {
 		for c.NextBlock() {
 			switch c.Val() {
-			case "dsn":
+			case "driver":
 				if !c.NextArg() {
 					return c.ArgErr()
 				}
-				m.dsn = c.Val()
+				switch c.Val() {
+				case mysqlDriver, postgresDriver, sqliteDriver:
+					m.driver = c.Val()
+				default:
+					return c.Err(unknownDriverError(c.Val()).Error())
+				}
+			case "dsn":
+				endpoint, err := parseEndpoint(c.RemainingArgs())
+				if err != nil {
+					return c.Err(err.Error())
+				}
+				m.endpoints = append(m.endpoints, endpoint)
 			case "dump_file":
 				if !c.NextArg() {
 					return c.ArgErr()
@@ -55,6 +82,117 @@ func (m *Mysql) parseConfig(c *caddy.Controller) error {
 				} else {
 					m.ttl = uint32(userTTL)
 				}
+			case "min_ttl":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userMinTTL, err := strconv.ParseUint(c.Val(), 10, 32)
+				if err != nil {
+					return c.Errf("invalid min_ttl '%s'", c.Val())
+				}
+				m.minTTL = uint32(userMinTTL)
+			case "max_ttl":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userMaxTTL, err := strconv.ParseUint(c.Val(), 10, 32)
+				if err != nil {
+					return c.Errf("invalid max_ttl '%s'", c.Val())
+				}
+				m.maxTTL = uint32(userMaxTTL)
+			case "cname_max_depth":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userCNAMEMaxDepth, err := strconv.Atoi(c.Val())
+				if err != nil || userCNAMEMaxDepth <= zero {
+					m.cnameMaxDepth = defaultCNAMEMaxDepth
+				} else {
+					m.cnameMaxDepth = userCNAMEMaxDepth
+				}
+			case "cname_external":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				switch c.Val() {
+				case clientExternal, nextExternal:
+					m.cnameExternal = c.Val()
+				default:
+					return c.Errf("unknown cname external '%s', must be one of %s, %s", c.Val(), clientExternal, nextExternal)
+				}
+			case "any_response":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				switch c.Val() {
+				case minimalAnyResponse, hinfoAnyResponse, allAnyResponse:
+					m.anyResponse = c.Val()
+				default:
+					return c.Errf("unknown any response '%s', must be one of %s, %s, %s", c.Val(), minimalAnyResponse, hinfoAnyResponse, allAnyResponse)
+				}
+			case "dnssec":
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return c.ArgErr()
+				}
+				zone := strings.ToLower(dns.Fqdn(args[zero]))
+				keys, err := readZoneKeys(zone, args[1:])
+				if err != nil {
+					return c.Errf("failed to read dnssec keys of %s: %s", zone, err)
+				}
+				if m.dnssecKeys == nil {
+					m.dnssecKeys = make(map[string]*zoneKeys)
+				}
+				m.dnssecKeys[zone] = keys
+			case "soa_serial":
+				args := c.RemainingArgs()
+				if len(args) == zero || len(args) > 2 {
+					return c.ArgErr()
+				}
+				switch args[zero] {
+				case dateSOASerial, unixtimeSOASerial:
+					m.soaSerial = args[zero]
+				default:
+					return c.Errf("unknown soa serial '%s', must be one of %s, %s", args[zero], dateSOASerial, unixtimeSOASerial)
+				}
+				if len(args) == 2 {
+					if args[1] != writeBackSOASerial {
+						return c.Errf("unknown soa serial option '%s', must be %s", args[1], writeBackSOASerial)
+					}
+					m.serialWriteBack = true
+				}
+				m.snapshotMode = true
+			case "notify":
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return c.ArgErr()
+				}
+				zone := strings.ToLower(dns.Fqdn(args[zero]))
+				if m.notifyTargets == nil {
+					m.notifyTargets = make(map[string][]string)
+				}
+				for _, address := range args[1:] {
+					target, err := parseNotifyTarget(address)
+					if err != nil {
+						return c.Err(err.Error())
+					}
+					m.notifyTargets[zone] = append(m.notifyTargets[zone], target)
+				}
+			case "update_record_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.updateRecordSQL = c.Val()
+			case "compress":
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+				m.compress = true
+			case "zone_ttl_column":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.zoneTTLColumn = c.Val()
 			case "zones_table":
 				if !c.NextArg() {
 					return c.ArgErr()
@@ -137,48 +275,174 @@ func (m *Mysql) parseConfig(c *caddy.Controller) error {
 					return c.ArgErr()
 				}
 				m.queryRecordSQL = c.Val()
+			case "query_host_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.queryHostSQL = c.Val()
+			case "query_name_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.queryNameSQL = c.Val()
+			case "snapshot":
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+				m.snapshotMode = true
+			case "query_all_record_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.queryAllRecordSQL = c.Val()
+			case "query_zone_record_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.queryZoneRecordSQL = c.Val()
+			case "change_column":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.changeColumn = c.Val()
+				m.snapshotMode = true
+			case "change_table":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.changeTable = c.Val()
+				m.snapshotMode = true
+			case "change_heartbeat_time":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userChangeHeartBeatTime, err := time.ParseDuration(c.Val())
+				if err != nil || userChangeHeartBeatTime <= zeroTime {
+					m.changeHeartbeatTime = defaultChangeHeartBeatTime
+				} else {
+					m.changeHeartbeatTime = userChangeHeartBeatTime
+				}
+			case "query_change_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.queryChangeSQL = c.Val()
+			case "query_change_mark_sql":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				m.queryChangeMarkSQL = c.Val()
+			case "query_timeout":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userQueryTimeout, err := time.ParseDuration(c.Val())
+				if err != nil || userQueryTimeout <= zeroTime {
+					m.queryTimeout = defaultQueryTimeout
+				} else {
+					m.queryTimeout = userQueryTimeout
+				}
+			case "degrade_max_entries":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userDegradeMaxEntries, err := strconv.Atoi(c.Val())
+				if err != nil || userDegradeMaxEntries < zero {
+					m.degradeMaxEntries = defaultDegradeMaxEntries
+				} else {
+					m.degradeMaxEntries = userDegradeMaxEntries
+				}
+			case "degrade_max_age":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userDegradeMaxAge, err := time.ParseDuration(c.Val())
+				if err != nil || userDegradeMaxAge < zeroTime {
+					m.degradeMaxAge = zeroTime
+				} else {
+					m.degradeMaxAge = userDegradeMaxAge
+				}
+			case "dump_interval":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userDumpInterval, err := time.ParseDuration(c.Val())
+				if err != nil || userDumpInterval < zeroTime {
+					m.dumpInterval = defaultDumpInterval
+				} else {
+					m.dumpInterval = userDumpInterval
+				}
+			case "dump_generations":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userDumpGenerations, err := strconv.Atoi(c.Val())
+				if err != nil || userDumpGenerations <= zero {
+					m.dumpGenerations = defaultDumpGenerations
+				} else {
+					m.dumpGenerations = userDumpGenerations
+				}
+			case "cold_start":
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+				m.coldStartMode = true
+			case "dump_compression":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				switch c.Val() {
+				case noneCompression, gzipCompression, zstdCompression:
+					m.dumpCompression = c.Val()
+				default:
+					return c.Errf("unknown dump compression '%s', must be one of %s, %s, %s", c.Val(), noneCompression, gzipCompression, zstdCompression)
+				}
+			case "binlog":
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+				m.binlogMode = true
+				m.snapshotMode = true
+			case "binlog_server_id":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				userBinlogServerID, err := strconv.ParseUint(c.Val(), 10, 32)
+				if err != nil || userBinlogServerID == zero {
+					return c.Errf("invalid binlog server id '%s'", c.Val())
+				}
+				m.binlogServerID = uint32(userBinlogServerID)
 			default:
 				return c.Errf("unknown property '%s'", c.Val())
 			}
 		}
 	}
-	return nil
-}
-
-func (m *Mysql) createTables() {
-	_, err := m.db.Exec(`
-        CREATE TABLE IF NOT EXISTS ` + m.zonesTable + ` (
-            id INT NOT NULL AUTO_INCREMENT,
-            zone_name VARCHAR(255) NOT NULL,
-            PRIMARY KEY (id),
-            UNIQUE KEY (zone_name)
-        );
-    `)
-	if err != nil {
-		createTableCount.With(prometheus.Labels{"status": "fail", "table_name": m.zonesTable}).Inc()
-		logger.Error(err)
-	} else {
-		createTableCount.With(prometheus.Labels{"status": "success", "table_name": m.zonesTable}).Inc()
+	if m.maxTTL > zero && m.minTTL > m.maxTTL {
+		return c.Err("min_ttl can not be greater than max_ttl")
 	}
-
-	_, err = m.db.Exec(`
-        CREATE TABLE IF NOT EXISTS ` + m.recordsTable + ` (
-            id INT NOT NULL AUTO_INCREMENT,
-            zone_id INT NOT NULL,
-            hostname VARCHAR(512) NOT NULL,
-            type VARCHAR(10) NOT NULL,
-            data VARCHAR(1024) NOT NULL,
-            ttl INT NOT NULL DEFAULT 120,
-			online INT NOT NULL DEFAULT 0,
-            PRIMARY KEY (id),
-            FOREIGN KEY (zone_id) REFERENCES ` + m.zonesTable + `(id)
-        );
-    `)
-	if err != nil {
-		createTableCount.With(prometheus.Labels{"status": "fail", "table_name": m.recordsTable}).Inc()
-		logger.Error(err)
-	} else {
-		createTableCount.With(prometheus.Labels{"status": "success", "table_name": m.recordsTable}).Inc()
-
+	if m.changeColumn != "" && m.changeTable != "" {
+		return c.Err("change_column and change_table can not be used together")
+	}
+	if m.binlogMode && m.changeTracking() {
+		return c.Err("binlog can not be used together with change_column or change_table")
 	}
+	if m.binlogMode && m.driver != mysqlDriver {
+		return c.Errf("binlog is only supported by the %s driver", mysqlDriver)
+	}
+	if m.binlogMode && m.binlogServerID == zero {
+		m.binlogServerID = uniqueBinlogServerID()
+	}
+	if m.binlogMode {
+		// The replication client only connects over tcp
+		for _, endpoint := range m.endpoints {
+			dsnConfig, err := mysqldriver.ParseDSN(endpoint.dsn)
+			if err != nil {
+				return c.Errf("invalid dsn '%s': %s", endpointName(endpoint.dsn), err)
+			}
+			if dsnConfig.Net != "tcp" {
+				return c.Errf("binlog needs a tcp dsn, not %s", dsnConfig.Net)
+			}
+		}
+	}
+	return nil
 }
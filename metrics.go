@@ -57,6 +57,13 @@ var (
 		Help:      "Counter of make answer count.",
 	}, []string{"status"})
 
+	negativeAnswerCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "negative_answer_total",
+		Help:      "Counter of negative answer.",
+	}, []string{"rcode"})
+
 	dbPingCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
 		Subsystem: pluginName,
@@ -84,4 +91,102 @@ var (
 		Name:      "dump_local_data_total",
 		Help:      "Counter of dump local data.",
 	}, []string{"status"})
+
+	snapshotLoadCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "snapshot_load_total",
+		Help:      "Counter of zone snapshot load.",
+	}, []string{"status"})
+
+	snapshotRecordsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "snapshot_records",
+		Help:      "Gauge of records in the zone snapshot.",
+	})
+
+	changeApplyCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "change_apply_total",
+		Help:      "Counter of record changes apply.",
+	}, []string{"status"})
+
+	binlogSyncCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "binlog_sync_total",
+		Help:      "Counter of binlog sync.",
+	}, []string{"status"})
+
+	endpointUpGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "endpoint_up",
+		Help:      "Gauge of database endpoint health.",
+	}, []string{"endpoint", "role"})
+
+	endpointActiveGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "endpoint_active",
+		Help:      "Gauge of database endpoint used by lookups.",
+	}, []string{"endpoint", "role"})
+
+	degradeCacheSizeGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "degrade_cache_entries",
+		Help:      "Gauge of entries in the degrade cache.",
+	})
+
+	degradeCacheEvictionCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "degrade_cache_evictions_total",
+		Help:      "Counter of degrade cache evictions.",
+	}, []string{"reason"})
+
+	truncatedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "truncated_responses_total",
+		Help:      "Counter of responses truncated to the client buffer size.",
+	})
+
+	signatureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "dnssec_signatures_total",
+		Help:      "Counter of RRSIGs answered.",
+	}, []string{"status"})
+
+	notifyCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "notify_total",
+		Help:      "Counter of NOTIFY sent to secondaries.",
+	}, []string{"status"})
+
+	soaSerialCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "soa_serial_total",
+		Help:      "Counter of computed SOA serials.",
+	}, []string{"status"})
+
+	transferCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "transfers_total",
+		Help:      "Counter of outbound zone transfers.",
+	}, []string{"type", "status"})
+
+	cnameChainCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "cname_chain_total",
+		Help:      "Counter of CNAME chains followed.",
+	}, []string{"result"})
 )
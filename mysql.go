@@ -2,23 +2,26 @@ package coredns_mysql_extend
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"reflect"
-	"strings"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/prometheus/client_golang/prometheus"
 
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/miekg/dns"
 )
 
 var logger = clog.NewWithPlugin(pluginName)
 
 func (m *Mysql) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	var records []record
+	var (
+		records      []record
+		cnameRecords []record
+		// nameExists tells NODATA from NXDOMAIN for the negative answer
+		nameExists bool
+	)
 	state := request.Request{W: w, Req: r}
 	answers := make([]dns.RR, 0)
 	rrStrings := make([]string, 0)
@@ -30,6 +33,11 @@ func (m *Mysql) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 
 	logger.Debugf("New query: FQDN %s type %s", qName, qType)
 
+	// Zone transfers are served by the transfer plugin through Transfer
+	if qType == axfrQtype || qType == ixfrQtype {
+		return plugin.NextOrFailure(m.Name(), m.Next, ctx, w, r)
+	}
+
 	// Query zone cache
 	zoneID, host, zone, err := m.getDomainInfo(qName)
 
@@ -38,94 +46,66 @@ func (m *Mysql) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		goto DegradeEntrypoint
 	}
 
-	// Query DB, full match
-	records, err = m.getRecords(zoneID, host, zone, qType)
+	// Query DB, full match, then CNAME, then the wildcard of the closest encloser, RFC 4592
+	records, cnameRecords, nameExists, err = m.lookup(ctx, qName, zoneID, host, zone, qType)
 	if err != nil {
 		goto DegradeEntrypoint
 	}
 
-	// Try query CNAME type of record
-	if len(records) == zero {
-		cnameRecords, err := m.getRecords(zoneID, host, zone, cnameQtype)
+	// Follow the CNAME chain, or the DNAME redirection
+	if len(records) == zero && len(cnameRecords) > zero {
+		cnameAnswers, cnameStrings, target, err := m.chaseCNAME(ctx, w, r, qName, cnameRecords, qType)
+		if errors.Is(err, errCNAMELoop) {
+			logger.Warningf("Query %s type %s: %s", qName, qType, err)
+			return dns.RcodeServerFailure, err
+		}
+		// RFC 6672 section 2.2, the DNAME is answered with YXDOMAIN
+		if errors.Is(err, errDNAMEOverflow) {
+			msg := MakeMessage(r, cnameAnswers)
+			msg.Authoritative = true
+			msg.Rcode = dns.RcodeYXDomain
+			m.writeMsg(ctx, state, msg)
+			return dns.RcodeSuccess, nil
+		}
 		if err != nil {
 			goto DegradeEntrypoint
 		}
-		for _, cnameRecord := range cnameRecords {
-			cnameZoneID, cnameHost, cnameZone, err := m.getDomainInfo(cnameRecord.data)
-
-			if err != nil {
-				goto DegradeEntrypoint
-			}
-
-			rrString := fmt.Sprintf("%s %d IN %s %s", qName, cnameRecord.ttl, cnameRecord.qType, cnameRecord.data)
-			rrStrings = append(rrStrings, rrString)
-			rr, err := m.makeAnswer(rrString)
-			if err != nil {
-				continue
-			}
-			answers = append(answers, rr)
-
-			cname2Records, err := m.getRecords(cnameZoneID, cnameHost, cnameZone, qType)
-
+		// The chain ends at a name of our zones without records, the negative answer is the one of that name
+		if target != nil {
+			msg, err := m.makeNegativeMessage(ctx, r, target.zoneID, target.zone, target.exists)
 			if err != nil {
 				goto DegradeEntrypoint
 			}
-
-			for _, cname2Record := range cname2Records {
-				rrString := fmt.Sprintf("%s %d IN %s %s", cname2Record.fqdn, cname2Record.ttl, cname2Record.qType, cname2Record.data)
-				rrStrings = append(rrStrings, rrString)
-				rr, err := m.makeAnswer(rrString)
-				if err != nil {
-					continue
+			if msg != nil {
+				msg.Answer = cnameAnswers
+				if state.Do() && m.zoneKeys(target.zone) != nil {
+					if err := m.compactDenial(ctx, msg, target.name, target.zoneID, target.host, target.zone, target.exists); err != nil {
+						goto DegradeEntrypoint
+					}
 				}
-				answers = append(answers, rr)
+				m.writeMsg(ctx, state, msg)
+				logger.Debugf("NegativeEntrypoint: %s for %s type %s at the end of its CNAME chain %s", dns.RcodeToString[msg.Rcode], qName, qType, target.name)
+				return dns.RcodeSuccess, nil
 			}
 		}
+		answers = append(answers, cnameAnswers...)
+		rrStrings = append(rrStrings, cnameStrings...)
 	}
 
 	// Process records
-	for _, record := range records {
-		rrString := fmt.Sprintf("%s %d IN %s %s", record.fqdn, record.ttl, record.qType, record.data)
-		rrStrings = append(rrStrings, rrString)
-		rr, err := m.makeAnswer(rrString)
-		if err != nil {
-			continue
-		}
-		answers = append(answers, rr)
-	}
-
-	// Handle wildcard domains
-	if len(answers) == zero && strings.Count(qName, zoneSeparator) > 1 {
-		baseZone := m.getBaseZone(qName)
-		zoneID, ok := m.getZoneID(baseZone)
-		wildcardName := wildcard + zoneSeparator + baseZone
-		if !ok {
-			logger.Debugf("Failed to get zone %s from database: %s", qName, err)
-			goto DegradeEntrypoint
-		}
-		records, err := m.getRecords(zoneID, wildcard, zone, qType)
-		if err != nil {
-			logger.Debugf("Failed to get records for domain %s from database: %s", wildcardName, err)
-			goto DegradeEntrypoint
-		}
-
-		for _, record := range records {
-			rrString := fmt.Sprintf("%s %d IN %s %s", qName, record.ttl, record.qType, record.data)
-			rr, err := m.makeAnswer(rrString)
-			rrStrings = append(rrStrings, rrString)
-			if err != nil {
-				continue
-			}
-			answers = append(answers, rr)
-		}
+	if len(records) > zero {
+		recordAnswers, recordStrings := m.makeAnswers(records)
+		answers = append(answers, recordAnswers...)
+		rrStrings = append(rrStrings, recordStrings...)
 	}
 
 	// Common Entrypoint
 	if len(answers) > zero {
-		msg := MakeMessage(r, answers)
-		err = w.WriteMsg(msg)
-		if err != nil {
-			logger.Error(err)
+		msg := m.makeAuthoritativeMessage(ctx, state, answers, zoneID, zone)
+		m.writeMsg(ctx, state, msg)
+		// In snapshot mode the degrade cache is serialized from the snapshot instead
+		if m.snapshotMode {
+			return dns.RcodeSuccess, nil
 		}
 		dnsRecordInfo := dnsRecordInfo{rrStrings: rrStrings, response: answers}
 		if cacheDnsRecordResponse, ok := m.degradeQuery(degradeRecord); !ok || !reflect.DeepEqual(cacheDnsRecordResponse, dnsRecordInfo.response) {
@@ -136,6 +116,21 @@ func (m *Mysql) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		}
 		return dns.RcodeSuccess, nil
 	}
+
+	// Negative Entrypoint, the zone is ours but no record matched
+	if msg, err := m.makeNegativeMessage(ctx, r, zoneID, zone, nameExists); err != nil {
+		goto DegradeEntrypoint
+	} else if msg != nil {
+		// Signed zones prove the negative answer, RFC 4035 section 3.1.3
+		if state.Do() && m.zoneKeys(zone) != nil {
+			if err := m.compactDenial(ctx, msg, qName, zoneID, host, zone, nameExists); err != nil {
+				goto DegradeEntrypoint
+			}
+		}
+		m.writeMsg(ctx, state, msg)
+		logger.Debugf("NegativeEntrypoint: %s for %s type %s", dns.RcodeToString[msg.Rcode], qName, qType)
+		return dns.RcodeSuccess, nil
+	}
 	logger.Debug("Call next plugin")
 	return plugin.NextOrFailure(m.Name(), m.Next, ctx, w, r)
 
@@ -143,10 +138,7 @@ func (m *Mysql) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 DegradeEntrypoint:
 	if answers, ok := m.degradeQuery(degradeRecord); ok {
 		msg := MakeMessage(r, answers)
-		err = w.WriteMsg(msg)
-		if err != nil {
-			logger.Error(err)
-		}
+		m.writeMsg(ctx, state, msg)
 		logger.Debugf("DegradeEntrypoint: Query degrade record %#v", degradeRecord)
 		return dns.RcodeSuccess, nil
 	}
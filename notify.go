@@ -0,0 +1,167 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// notifier remembers the last seen serial and content of the zones having secondaries to notify, a change of
+// either sends them a NOTIFY, RFC 1996.
+type notifier struct {
+	sync.Mutex
+	// zones are the last seen states by lower case zone name
+	zones map[string]notifyState
+	// cancels stop the NOTIFY still retried for the previous change of a zone
+	cancels map[string]context.CancelFunc
+}
+
+// notifyState is a zone as last seen, the hash is only known in snapshot mode.
+type notifyState struct {
+	serial uint32
+	hash   [sha256.Size]byte
+}
+
+func newNotifier() *notifier {
+	return &notifier{zones: make(map[string]notifyState), cancels: make(map[string]context.CancelFunc)}
+}
+
+// parseNotifyTarget returns address with the DNS port if it has none.
+func parseNotifyTarget(address string) (string, error) {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address, nil
+	}
+	if strings.Contains(address, ":") && net.ParseIP(address) == nil {
+		return "", fmt.Errorf("invalid notify address '%s'", address)
+	}
+	return net.JoinHostPort(address, "53"), nil
+}
+
+// seen reports whether a state of zone was seen.
+func (n *notifier) seen(zone string) bool {
+	n.Lock()
+	defer n.Unlock()
+	_, ok := n.zones[zone]
+	return ok
+}
+
+// notifySnapshot notifies the secondaries of the zones of snapshot whose serial or records changed, only the zones
+// touched since the older snapshot are checked.
+func (m *Mysql) notifySnapshot(snapshot *zoneSnapshot) {
+	if len(m.notifyTargets) == zero {
+		return
+	}
+	for name, zoneID := range snapshot.zoneMap {
+		zone := strings.ToLower(name)
+		if len(m.notifyTargets[zone]) == zero || (m.notifier.seen(zone) && !snapshot.touched[zoneID]) {
+			continue
+		}
+		soaRecords := snapshot.getRecords(zoneID, zoneSelf, soaQtype)
+		if len(soaRecords) == zero {
+			continue
+		}
+		soaRecord := soaRecords[zero]
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", soaRecord.fqdn, m.recordTTL(soaRecord), soaRecord.qType, soaRecord.data))
+		soa, ok := rr.(*dns.SOA)
+		if err != nil || !ok {
+			continue
+		}
+		m.zoneChanged(zone, soa, zoneHash(snapshot, zoneID, soa))
+	}
+}
+
+// notifyZones notifies the secondaries of the zones of zoneMap whose serial changed, outside of snapshot mode
+// the records are not known so a change without a new serial is not noticed.
+func (m *Mysql) notifyZones(zoneMap map[string]int) {
+	for name, zoneID := range zoneMap {
+		zone := strings.ToLower(name)
+		if len(m.notifyTargets[zone]) == zero {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(m.ctx, m.queryTimeout)
+		soa, err := m.getZoneSOA(ctx, zoneID, name)
+		cancel()
+		if err != nil {
+			logger.Errorf("Failed to get SOA of zone %s to notify: %s", zone, err)
+			continue
+		}
+		if soa != nil {
+			m.zoneChanged(zone, soa, [sha256.Size]byte{})
+		}
+	}
+}
+
+// zoneChanged sends a NOTIFY to the secondaries of zone if soa or hash differ from the last seen ones, a zero hash
+// is not compared. The first state seen of a zone only becomes the reference.
+func (m *Mysql) zoneChanged(zone string, soa *dns.SOA, hash [sha256.Size]byte) {
+	m.notifier.Lock()
+	defer m.notifier.Unlock()
+	state := notifyState{serial: soa.Serial, hash: hash}
+	last, ok := m.notifier.zones[zone]
+	// Without the records only the serial is compared
+	if hash == [sha256.Size]byte{} {
+		state.hash = last.hash
+	}
+	m.notifier.zones[zone] = state
+	if !ok || last == state {
+		return
+	}
+	logger.Infof("Zone %s changed, notify %s of serial %d", zone, strings.Join(m.notifyTargets[zone], ", "), soa.Serial)
+
+	if cancel, ok := m.notifier.cancels[zone]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.notifier.cancels[zone] = cancel
+	for _, target := range m.notifyTargets[zone] {
+		target := target
+		m.startWorker(func() { m.sendNotify(ctx, zone, soa, target) })
+	}
+}
+
+// sendNotify sends a NOTIFY of zone to target until it is answered, up to notifyAttempts times.
+func (m *Mysql) sendNotify(ctx context.Context, zone string, soa *dns.SOA, target string) {
+	msg := new(dns.Msg)
+	msg.SetNotify(zone)
+	msg.Authoritative = true
+	// The SOA is a hint of the new serial, RFC 1996 section 3.7
+	msg.Answer = []dns.RR{soa}
+	client := &dns.Client{Timeout: notifyTimeout}
+
+	interval := notifyRetryTime
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		reply, _, err := client.ExchangeContext(ctx, msg, target)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && reply.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("answered %s", dns.RcodeToString[reply.Rcode])
+		}
+		if err == nil {
+			logger.Debugf("Success to notify %s of zone %s serial %d", target, zone, soa.Serial)
+			notifyCount.With(prometheus.Labels{"status": "success"}).Inc()
+			return
+		}
+		if attempt == notifyAttempts {
+			logger.Errorf("Failed to notify %s of zone %s serial %d: %s", target, zone, soa.Serial, err)
+			notifyCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			return
+		}
+		logger.Warningf("Failed to notify %s of zone %s, retry in %s: %s", target, zone, interval, err)
+		notifyCount.With(prometheus.Labels{"status": "retry"}).Inc()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
@@ -0,0 +1,167 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// zoneSerials keeps the serial computed for each zone by soa_serial, with the hash of the zone content it was
+// computed for. A zone gets a new serial whenever its content hash changes.
+type zoneSerials struct {
+	sync.Mutex
+	// zones are the serials by lower case zone name
+	zones map[string]zoneSerial
+}
+
+type zoneSerial struct {
+	hash   [sha256.Size]byte
+	serial uint32
+}
+
+// serialWrite is a SOA row whose data is written back with the computed serial.
+type serialWrite struct {
+	zone string
+	id   int
+	data string
+}
+
+func newZoneSerials() *zoneSerials {
+	return &zoneSerials{zones: make(map[string]zoneSerial)}
+}
+
+// setSerials rewrites the SOA records of snapshot with the serials computed from the zone contents, it must be
+// called before snapshot is swapped in. Only the zones touched since the older snapshot are hashed again.
+// It returns the SOA rows to write back.
+func (m *Mysql) setSerials(snapshot *zoneSnapshot) []serialWrite {
+	if m.soaSerial == "" {
+		return nil
+	}
+	m.serials.Lock()
+	defer m.serials.Unlock()
+
+	var writes []serialWrite
+	now := time.Now()
+	zones := make(map[string]bool, len(snapshot.zoneMap))
+	for name, zoneID := range snapshot.zoneMap {
+		zone := strings.ToLower(name)
+		zones[zone] = true
+		// The records of an untouched zone, its rewritten SOA included, are the ones of the older snapshot
+		if _, ok := m.serials.zones[zone]; ok && !snapshot.touched[zoneID] {
+			continue
+		}
+		soaRecords := snapshot.getRecords(zoneID, zoneSelf, soaQtype)
+		if len(soaRecords) == zero {
+			continue
+		}
+		soaRecord := soaRecords[zero]
+		rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", soaRecord.fqdn, soaRecord.qType, soaRecord.data))
+		soa, ok := rr.(*dns.SOA)
+		if err != nil || !ok {
+			continue
+		}
+
+		stored := soa.Serial
+		hash := zoneHash(snapshot, zoneID, soa)
+		base := m.baseSerial(now)
+		current, ok := m.serials.zones[zone]
+		var serial uint32
+		switch {
+		case !ok:
+			// The content may have changed while we were down, start from the base unless stored is newer
+			serial = newerSerial(stored, base)
+		case current.hash == hash:
+			// A serial bumped by hand in the table is kept
+			serial = newerSerial(stored, current.serial)
+		default:
+			serial = newerSerial(newerSerial(stored, current.serial)+1, base)
+			logger.Infof("Zone %s changed, serial %d -> %d", zone, current.serial, serial)
+			soaSerialCount.With(prometheus.Labels{"status": "changed"}).Inc()
+		}
+		m.serials.zones[zone] = zoneSerial{hash: hash, serial: serial}
+		if serial == stored {
+			continue
+		}
+
+		soa.Serial = serial
+		soaRecord.data = strings.TrimSpace(strings.TrimPrefix(soa.String(), soa.Hdr.String()))
+		snapshot.remove(soaRecord.id)
+		snapshot.add(soaRecord)
+		if m.serialWriteBack {
+			writes = append(writes, serialWrite{zone: zone, id: soaRecord.id, data: soaRecord.data})
+		}
+	}
+	for zone := range m.serials.zones {
+		if !zones[zone] {
+			delete(m.serials.zones, zone)
+		}
+	}
+	return writes
+}
+
+// baseSerial returns the lowest serial of a change made at now.
+func (m *Mysql) baseSerial(now time.Time) uint32 {
+	if m.soaSerial == unixtimeSOASerial {
+		return uint32(now.Unix())
+	}
+	date, _ := strconv.ParseUint(now.UTC().Format("20060102"), 10, 32)
+	return uint32(date * 100)
+}
+
+// newerSerial returns the newer of serials a and b, RFC 1982.
+func newerSerial(a, b uint32) uint32 {
+	if serialNewer(b, a) {
+		return b
+	}
+	return a
+}
+
+// zoneHash hashes the content of zone zoneID, its SOA record without the serial included.
+func zoneHash(snapshot *zoneSnapshot, zoneID int, soa *dns.SOA) [sha256.Size]byte {
+	records := snapshot.zoneRecords(zoneID)
+	contents := make([]string, zero, len(records)+1)
+	for _, record := range records {
+		content := record.content()
+		contents = append(contents, fmt.Sprintf("%s %d %s %s", content.name, content.ttl, content.qType, content.data))
+	}
+	soa = dns.Copy(soa).(*dns.SOA)
+	soa.Serial = zero
+	contents = append(contents, soa.String())
+	// Records may come back with another id, their order does not matter
+	sort.Strings(contents)
+	return sha256.Sum256([]byte(strings.Join(contents, "\n")))
+}
+
+// writeSerials writes the computed serials back to the SOA rows, through the primary database as the active one
+// may be a read only replica.
+func (m *Mysql) writeSerials(writes []serialWrite) {
+	if len(writes) == zero {
+		return
+	}
+	backend := m.primaryBackend()
+	if backend == nil {
+		logger.Errorf("Failed to write back SOA serials: %s", errBackendNotReady)
+		soaSerialCount.With(prometheus.Labels{"status": "fail"}).Inc()
+		return
+	}
+	for _, write := range writes {
+		ctx, cancel := context.WithTimeout(m.ctx, m.queryTimeout)
+		err := backend.UpdateRecordData(ctx, write.id, write.data)
+		cancel()
+		if err != nil {
+			logger.Errorf("Failed to write back SOA serial of zone %s: %s", write.zone, err)
+			soaSerialCount.With(prometheus.Labels{"status": "fail"}).Inc()
+			continue
+		}
+		logger.Debugf("Success to write back SOA serial of zone %s: %s", write.zone, write.data)
+		soaSerialCount.With(prometheus.Labels{"status": "written"}).Inc()
+	}
+}
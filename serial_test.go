@@ -0,0 +1,87 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSetSerials checks serials are bumped on changes of the touched zones only and written back to the primary
+// while a replica is the active database.
+func TestSetSerials(t *testing.T) {
+	dir := t.TempDir()
+	primary, replica := filepath.Join(dir, "primary.db"), filepath.Join(dir, "replica.db")
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s primary\ndsn file:%s replica\nsoa_serial date write_back", primary, replica))
+	m.openEndpoints()
+	t.Cleanup(m.closeEndpoints)
+	if m.activeEndpoint.role != replicaRole {
+		t.Fatalf("active endpoint is %s, want the replica", m.activeEndpoint.role)
+	}
+	db := m.primaryBackend().(*sqliteBackend).db
+	m.primaryBackend().CreateSchema(context.Background())
+	for _, statement := range []string{
+		"INSERT INTO zones(id, zone_name) VALUES(1, 'example.org.'), (2, 'example.net.')",
+		"INSERT INTO records(id, zone_id, hostname, type, data, ttl, online) VALUES(1, 1, '@', 'SOA', 'ns.example.org. h.example.org. 1 3600 600 86400 300', 60, 1)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	soa := record{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60}
+	www := record{id: 2, zoneID: 1, name: "www", qType: "A", data: "10.0.0.1", ttl: 60}
+	other := record{id: 3, zoneID: 2, name: "www", qType: "A", data: "10.0.0.3", ttl: 60}
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1, "example.net.": 2}, map[int]uint32{})
+	for _, record := range []record{soa, www, other} {
+		snapshot.add(record)
+	}
+
+	base := m.baseSerial(time.Now())
+	serial := func() uint32 {
+		t.Helper()
+		serial, ok := m.snapshot.Load().serial(1)
+		if !ok {
+			t.Fatal("zone has no serial")
+		}
+		return serial
+	}
+	stored := func() string {
+		t.Helper()
+		var data string
+		if err := db.QueryRow("SELECT data FROM records WHERE id=1").Scan(&data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	m.swapSnapshot(snapshot)
+	if got := serial(); got != base {
+		t.Errorf("first serial %d, want %d", got, base)
+	}
+	if got := stored(); !strings.Contains(got, fmt.Sprint(base)) {
+		t.Errorf("written back SOA %q, want serial %d", got, base)
+	}
+
+	www.data = "10.0.0.2"
+	snapshot = snapshot.apply([]recordChange{{record: www, online: true}})
+	m.swapSnapshot(snapshot)
+	if got := serial(); got != base+1 {
+		t.Errorf("serial after a change %d, want %d", got, base+1)
+	}
+
+	other.data = "10.0.0.4"
+	snapshot = snapshot.apply([]recordChange{{record: other, online: true}})
+	if snapshot.touched[1] {
+		t.Error("change of another zone touched the zone")
+	}
+	m.swapSnapshot(snapshot)
+	if got := serial(); got != base+1 {
+		t.Errorf("serial after a change of another zone %d, want %d", got, base+1)
+	}
+	if got := stored(); !strings.Contains(got, fmt.Sprint(base+1)) {
+		t.Errorf("written back SOA %q, want serial %d", got, base+1)
+	}
+}
@@ -23,11 +23,23 @@ func setup(c *caddy.Controller) error {
 	if err != nil {
 		logger.Fatalf("Parse configuration err: %s", err)
 	}
-	mysql.queryZoneSQL = fmt.Sprintf(mysql.queryZoneSQL, mysql.zonesTable)
-	mysql.queryRecordSQL = fmt.Sprintf(mysql.queryRecordSQL, mysql.recordsTable)
+	mysql.initEndpoints()
+	mysql.degradeCache = newRecordCache(mysql.degradeMaxEntries, mysql.degradeMaxAge)
+	mysql.signatures = newSignatureCache()
+	mysql.journal = newZoneJournal()
+	mysql.serials = newZoneSerials()
+	mysql.notifier = newNotifier()
+	mysql.formatSQL()
 
 	logger.Debugf("Query zone SQL: %s", mysql.queryZoneSQL)
 	logger.Debugf("Query record SQL: %s", mysql.queryRecordSQL)
+	logger.Debugf("Query host SQL: %s", mysql.queryHostSQL)
+	logger.Debugf("Query name SQL: %s", mysql.queryNameSQL)
+	logger.Debugf("Query all record SQL: %s", mysql.queryAllRecordSQL)
+	logger.Debugf("Query zone record SQL: %s", mysql.queryZoneRecordSQL)
+	logger.Debugf("Update record SQL: %s", mysql.updateRecordSQL)
+	logger.Debugf("Query change SQL: %s", mysql.queryChangeSQL)
+	logger.Debugf("Query change mark SQL: %s", mysql.queryChangeMarkSQL)
 
 	// Exec options when start up
 	c.OnStartup(mysql.onStartup)
@@ -44,3 +56,34 @@ func setup(c *caddy.Controller) error {
 	// All OK, return a nil error.
 	return nil
 }
+
+// formatSQL fills the table names into the SQL of the config.
+func (m *Mysql) formatSQL() {
+	if m.zoneTTLColumn != "" && m.queryZoneSQL == defaultQueryZoneSQL {
+		m.queryZoneSQL = fmt.Sprintf(defaultQueryZoneTTLSQL, m.zonesTable, m.zoneTTLColumn)
+	} else {
+		m.queryZoneSQL = fmt.Sprintf(m.queryZoneSQL, m.zonesTable)
+	}
+	m.queryRecordSQL = fmt.Sprintf(m.queryRecordSQL, m.recordsTable)
+	m.queryHostSQL = fmt.Sprintf(m.queryHostSQL, m.recordsTable)
+	m.queryNameSQL = fmt.Sprintf(m.queryNameSQL, m.recordsTable)
+	m.queryAllRecordSQL = fmt.Sprintf(m.queryAllRecordSQL, m.recordsTable)
+	m.queryZoneRecordSQL = fmt.Sprintf(m.queryZoneRecordSQL, m.recordsTable)
+	m.updateRecordSQL = fmt.Sprintf(m.updateRecordSQL, m.recordsTable)
+	if m.changeColumn != "" {
+		m.queryChangeSQL = formatChangeSQL(m.queryChangeSQL, defaultQueryChangeColumnSQL, m.recordsTable, m.changeColumn)
+		m.queryChangeMarkSQL = formatChangeSQL(m.queryChangeMarkSQL, defaultQueryChangeColumnMarkSQL, m.recordsTable, m.changeColumn)
+	}
+	if m.changeTable != "" {
+		m.queryChangeSQL = formatChangeSQL(m.queryChangeSQL, defaultQueryChangeTableSQL, m.recordsTable, m.changeTable)
+		m.queryChangeMarkSQL = formatChangeSQL(m.queryChangeMarkSQL, defaultQueryChangeTableMarkSQL, m.recordsTable, m.changeTable)
+	}
+}
+
+// formatChangeSQL fills the records table and the change column or table into the user SQL, or the default one.
+func formatChangeSQL(userSQL, defaultSQL, recordsTable, change string) string {
+	if userSQL == "" {
+		userSQL = defaultSQL
+	}
+	return fmt.Sprintf(userSQL, recordsTable, change)
+}
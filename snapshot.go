@@ -0,0 +1,328 @@
+package coredns_mysql_extend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errSnapshotNotReady = errors.New("zone snapshot not ready")
+
+// zoneSnapshot is an immutable in-memory index of every zone and online record.
+// A new snapshot is built on each refresh and swapped in atomically, so readers never lock.
+type zoneSnapshot struct {
+	zoneMap   map[string]int
+	zoneTTLs  map[int]uint32
+	zoneNames map[int]string
+	records   map[snapshotKey][]record
+	hosts     map[hostKey][]record
+	byID      map[int]record
+	// byZone indexes the records of each zone by id, the maps are shared with the older snapshots until touched
+	byZone map[int]map[int]record
+	// touched are the zones whose records changed since the snapshot was cloned, their byZone maps are its own
+	touched map[int]bool
+	// enclosers counts the records below each ancestor name, those names exist as empty non-terminals
+	enclosers map[hostKey]int
+}
+
+type snapshotKey struct {
+	zoneID int
+	host   string
+	qType  string
+}
+
+type hostKey struct {
+	zoneID int
+	host   string
+}
+
+// newSnapshotKey returns the index key of host and qType, normalized as MySQL compares them case insensitively.
+func newSnapshotKey(zoneID int, host, qType string) snapshotKey {
+	return snapshotKey{zoneID: zoneID, host: strings.ToLower(host), qType: strings.ToUpper(qType)}
+}
+
+func newHostKey(zoneID int, host string) hostKey {
+	return hostKey{zoneID: zoneID, host: strings.ToLower(host)}
+}
+
+func newZoneSnapshot(zoneMap map[string]int, zoneTTLs map[int]uint32) *zoneSnapshot {
+	zoneNames := make(map[int]string, len(zoneMap))
+	for name, id := range zoneMap {
+		zoneNames[id] = name
+	}
+	return &zoneSnapshot{
+		zoneMap:   zoneMap,
+		zoneTTLs:  zoneTTLs,
+		zoneNames: zoneNames,
+		records:   make(map[snapshotKey][]record),
+		hosts:     make(map[hostKey][]record),
+		byID:      make(map[int]record),
+		byZone:    make(map[int]map[int]record),
+		touched:   make(map[int]bool),
+		enclosers: make(map[hostKey]int),
+	}
+}
+
+// clone returns a shallow copy of the snapshot which can be changed without affecting readers of s.
+func (s *zoneSnapshot) clone() *zoneSnapshot {
+	next := &zoneSnapshot{
+		zoneMap:   s.zoneMap,
+		zoneTTLs:  s.zoneTTLs,
+		zoneNames: s.zoneNames,
+		records:   make(map[snapshotKey][]record, len(s.records)),
+		hosts:     make(map[hostKey][]record, len(s.hosts)),
+		byID:      make(map[int]record, len(s.byID)),
+		byZone:    make(map[int]map[int]record, len(s.byZone)),
+		touched:   make(map[int]bool),
+		enclosers: make(map[hostKey]int, len(s.enclosers)),
+	}
+	for key, records := range s.records {
+		next.records[key] = records
+	}
+	for key, records := range s.hosts {
+		next.hosts[key] = records
+	}
+	for id, record := range s.byID {
+		next.byID[id] = record
+	}
+	for zoneID, records := range s.byZone {
+		next.byZone[zoneID] = records
+	}
+	for key, count := range s.enclosers {
+		next.enclosers[key] = count
+	}
+	return next
+}
+
+// zoneIndex returns the byZone map of zoneID, copied on the first change of the zone since the clone.
+func (s *zoneSnapshot) zoneIndex(zoneID int) map[int]record {
+	if !s.touched[zoneID] {
+		records := make(map[int]record, len(s.byZone[zoneID])+1)
+		for id, record := range s.byZone[zoneID] {
+			records[id] = record
+		}
+		s.byZone[zoneID] = records
+		s.touched[zoneID] = true
+	}
+	return s.byZone[zoneID]
+}
+
+// add indexes record, it returns false if the record belongs to an unknown zone.
+func (s *zoneSnapshot) add(record record) bool {
+	zone, ok := s.zoneNames[record.zoneID]
+	if !ok {
+		return false
+	}
+	record.setZone(zone)
+	// Full slice expressions force a copy, the backing arrays may be shared with older snapshots
+	key := newSnapshotKey(record.zoneID, record.name, record.qType)
+	records := s.records[key]
+	s.records[key] = append(records[:len(records):len(records)], record)
+	hKey := newHostKey(record.zoneID, record.name)
+	hosts := s.hosts[hKey]
+	s.hosts[hKey] = append(hosts[:len(hosts):len(hosts)], record)
+	s.byID[record.id] = record
+	s.zoneIndex(record.zoneID)[record.id] = record
+	s.countEnclosers(record, 1)
+	return true
+}
+
+// remove drops the record with id from the index.
+func (s *zoneSnapshot) remove(id int) {
+	old, ok := s.byID[id]
+	if !ok {
+		return
+	}
+	delete(s.byID, id)
+	delete(s.zoneIndex(old.zoneID), id)
+	s.countEnclosers(old, -1)
+
+	key := newSnapshotKey(old.zoneID, old.name, old.qType)
+	if records := withoutRecord(s.records[key], id); len(records) > zero {
+		s.records[key] = records
+	} else {
+		delete(s.records, key)
+	}
+	hKey := newHostKey(old.zoneID, old.name)
+	if hosts := withoutRecord(s.hosts[hKey], id); len(hosts) > zero {
+		s.hosts[hKey] = hosts
+	} else {
+		delete(s.hosts, hKey)
+	}
+}
+
+// apply returns a new snapshot with changes applied, offline or deleted records are removed.
+func (s *zoneSnapshot) apply(changes []recordChange) *zoneSnapshot {
+	next := s.clone()
+	for _, change := range changes {
+		next.remove(change.record.id)
+		if change.online && !next.add(change.record) {
+			logger.Warningf("Record %d references unknown zone id %d", change.record.id, change.record.zoneID)
+		}
+	}
+	return next
+}
+
+// countEnclosers adds delta to the count of every ancestor name of record inside its zone.
+func (s *zoneSnapshot) countEnclosers(record record, delta int) {
+	if record.name == zoneSelf {
+		return
+	}
+	labels := strings.Split(record.name, zoneSeparator)
+	for i := 1; i < len(labels); i++ {
+		key := newHostKey(record.zoneID, strings.Join(labels[i:], zoneSeparator))
+		if s.enclosers[key] += delta; s.enclosers[key] <= zero {
+			delete(s.enclosers, key)
+		}
+	}
+}
+
+func withoutRecord(records []record, id int) []record {
+	result := make([]record, zero, len(records))
+	for _, record := range records {
+		if record.id != id {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+func (s *zoneSnapshot) getRecords(zoneID int, host, qType string) []record {
+	return s.records[newSnapshotKey(zoneID, host, qType)]
+}
+
+func (s *zoneSnapshot) getHostRecords(zoneID int, host string) []record {
+	return s.hosts[newHostKey(zoneID, host)]
+}
+
+// nameExists reports whether host owns records or is an empty non-terminal.
+func (s *zoneSnapshot) nameExists(zoneID int, host string) bool {
+	key := newHostKey(zoneID, host)
+	return len(s.hosts[key]) > zero || s.enclosers[key] > zero
+}
+
+func (s *zoneSnapshot) count() int {
+	return len(s.byID)
+}
+
+// degradeCache serializes the snapshot into the degrade cache layout used by the dump file, ttl gives the answered ttl of a record.
+func (s *zoneSnapshot) degradeCache(ttl func(record) uint32) map[record]dnsRecordInfo {
+	cache := make(map[record]dnsRecordInfo, len(s.records))
+	for _, records := range s.records {
+		var dnsRecordInfo dnsRecordInfo
+		for _, record := range records {
+			rrString := fmt.Sprintf("%s %d IN %s %s", record.fqdn, ttl(record), record.qType, record.data)
+			rr, err := dns.NewRR(rrString)
+			if err != nil || rr == nil {
+				continue
+			}
+			dnsRecordInfo.rrStrings = append(dnsRecordInfo.rrStrings, rrString)
+			dnsRecordInfo.response = append(dnsRecordInfo.response, rr)
+		}
+		if len(records) > zero && len(dnsRecordInfo.response) > zero {
+			// Keyed like the queries, by lower case name and upper case type
+			cache[record{fqdn: strings.ToLower(records[0].fqdn), qType: strings.ToUpper(records[0].qType)}] = dnsRecordInfo
+		}
+	}
+	return cache
+}
+
+// loadSnapshot reads all zones and online records from the database into a new snapshot.
+func (m *Mysql) loadSnapshot() (*zoneSnapshot, error) {
+	zoneMap, zoneTTLs, err := m.queryZones()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newZoneSnapshot(zoneMap, zoneTTLs)
+
+	backend, err := m.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	records, err := backend.ListRecords(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if !snapshot.add(record) {
+			logger.Warningf("Record %d references unknown zone id %d", record.id, record.zoneID)
+		}
+	}
+	return snapshot, nil
+}
+
+func (m *Mysql) reLoadSnapshot() {
+	resumed := false
+	for {
+		// Take the change mark before the full load, changes made during the load are applied again later
+		changeMark, err := m.queryChangeMark()
+		if err != nil {
+			logger.Errorf("Failed to query change mark: %s", err)
+			snapshotLoadCount.With(prometheus.Labels{"status": "fail"}).Inc()
+
+			if !m.sleep(m.failHeartbeatTime) {
+				return
+			}
+			continue
+		}
+		snapshot, err := m.loadSnapshot()
+		if err != nil {
+			logger.Errorf("Failed to load zone snapshot: %s", err)
+			snapshotLoadCount.With(prometheus.Labels{"status": "fail"}).Inc()
+
+			if !m.sleep(m.failHeartbeatTime) {
+				return
+			}
+			continue
+		}
+		m.swapSnapshot(snapshot)
+		logger.Debugf("Success to load zone snapshot: %d zones, %d records", len(snapshot.zoneMap), snapshot.count())
+		snapshotLoadCount.With(prometheus.Labels{"status": "success"}).Inc()
+
+		if m.changeTracking() {
+			// Only returns when a full reload is needed
+			m.reApplyChanges(snapshot, changeMark)
+			if m.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		if m.binlogMode {
+			position := changeMark.(binlogPosition)
+			// Resume from the persisted position once after start up, row events carry full images so replaying is harmless
+			if saved, ok := m.loadBinlogPosition(); ok && !resumed {
+				position = saved
+			}
+			resumed = true
+			// Only returns when a full reload is needed, or after a fail heartbeat when the binlog can not be followed
+			if err := m.followBinlog(snapshot, position); err != nil {
+				logger.Errorf("Failed to follow binlog: %s", err)
+				binlogSyncCount.With(prometheus.Labels{"status": "fail"}).Inc()
+				if !m.sleep(m.failHeartbeatTime) {
+					return
+				}
+			}
+			if m.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		if !m.sleep(m.successHeartbeatTime) {
+			return
+		}
+	}
+}
+
+// swapSnapshot makes snapshot the one answering queries.
+func (m *Mysql) swapSnapshot(snapshot *zoneSnapshot) {
+	writes := m.setSerials(snapshot)
+	m.snapshot.Store(snapshot)
+	m.setZoneMap(snapshot.zoneMap, snapshot.zoneTTLs)
+	m.journal.update(snapshot)
+	m.notifySnapshot(snapshot)
+	snapshotRecordsGauge.Set(float64(len(snapshot.byID)))
+	m.writeSerials(writes)
+}
@@ -0,0 +1,66 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// TestSnapshotCase checks the snapshot finds rows whatever the case of their hostname and type, as MySQL does.
+func TestSnapshotCase(t *testing.T) {
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1}, map[int]uint32{})
+	snapshot.add(record{id: 1, zoneID: 1, name: "WWW", qType: "a", data: "10.0.0.1"})
+	snapshot.add(record{id: 2, zoneID: 1, name: "Mail.Sub", qType: "Mx", data: "10 mx.example.org."})
+
+	if got := snapshot.getRecords(1, "www", "A"); len(got) != 1 {
+		t.Errorf("got %d records of www A, want 1", len(got))
+	}
+	if got := snapshot.getHostRecords(1, "mail.sub"); len(got) != 1 {
+		t.Errorf("got %d records of mail.sub, want 1", len(got))
+	}
+	if !snapshot.nameExists(1, "sub") {
+		t.Error("empty non-terminal sub does not exist")
+	}
+	snapshot = snapshot.apply([]recordChange{{record: record{id: 1, zoneID: 1, name: "WWW", qType: "a"}}})
+	if got := snapshot.getRecords(1, "www", "A"); len(got) != zero {
+		t.Errorf("got %d records of www A after removal, want 0", len(got))
+	}
+}
+
+// newSnapshotMysql returns a plugin configured by body answering from a snapshot of zones and records.
+func newSnapshotMysql(t *testing.T, body string, zones map[string]int, records ...record) *Mysql {
+	t.Helper()
+	m := newTestMysql(t, "snapshot\n"+body)
+	snapshot := newZoneSnapshot(zones, map[int]uint32{})
+	for _, record := range records {
+		if !snapshot.add(record) {
+			t.Fatalf("record %d of unknown zone %d", record.id, record.zoneID)
+		}
+	}
+	m.swapSnapshot(snapshot)
+	return m
+}
+
+// exchange serves a query of name and qType without EDNS0.
+func exchange(t *testing.T, m *Mysql, name string, qType uint16) *dns.Msg {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(name, qType)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := m.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Msg
+}
+
+// rrStrings returns the presentation format of rrs.
+func rrStrings(rrs []dns.RR) []string {
+	var strs []string
+	for _, rr := range rrs {
+		strs = append(strs, rr.String())
+	}
+	return strs
+}
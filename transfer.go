@@ -0,0 +1,297 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errNoSOA          = errors.New("zone has no SOA record")
+	errSignedTransfer = errors.New("signed zone can not be transferred")
+)
+
+// zoneJournal keeps the differences between the last serials of each zone for IXFR, RFC 1995. They are found
+// by comparing the snapshots swapped in whenever the serial of a zone changes, outside of snapshot mode there
+// is no journal and IXFR falls back to AXFR.
+type zoneJournal struct {
+	sync.Mutex
+	// bases are the zones at their last journaled serial, by lower case zone name
+	bases  map[string]zoneBase
+	deltas map[string][]zoneDelta
+}
+
+// zoneBase is a zone at a journaled serial, without the SOA records.
+type zoneBase struct {
+	serial  uint32
+	records []record
+}
+
+// zoneDelta is the change of a zone from one serial to the next, without the SOA records.
+type zoneDelta struct {
+	from    uint32
+	to      uint32
+	removed []record
+	added   []record
+}
+
+// recordContent identifies a record by what is transferred, ids are not part of a zone.
+type recordContent struct {
+	name  string
+	qType string
+	data  string
+	ttl   uint32
+}
+
+func newZoneJournal() *zoneJournal {
+	return &zoneJournal{bases: make(map[string]zoneBase), deltas: make(map[string][]zoneDelta)}
+}
+
+// update journals the changes of the zones of snapshot whose serial changed since their base, only the zones
+// touched since the older snapshot may have changed.
+func (j *zoneJournal) update(snapshot *zoneSnapshot) {
+	j.Lock()
+	defer j.Unlock()
+	zones := make(map[string]bool, len(snapshot.zoneMap))
+	for name, zoneID := range snapshot.zoneMap {
+		zone := strings.ToLower(name)
+		zones[zone] = true
+		base, ok := j.bases[zone]
+		if ok && !snapshot.touched[zoneID] {
+			continue
+		}
+		serial, hasSerial := snapshot.serial(zoneID)
+		if !hasSerial {
+			delete(j.bases, zone)
+			delete(j.deltas, zone)
+			continue
+		}
+		if ok && base.serial == serial {
+			continue
+		}
+		records := snapshot.zoneRecords(zoneID)
+		j.bases[zone] = zoneBase{serial: serial, records: records}
+		if !ok {
+			continue
+		}
+		if !serialNewer(serial, base.serial) {
+			// The serial went back, older deltas do not lead to it anymore
+			delete(j.deltas, zone)
+			continue
+		}
+		removed, added := diffZone(base.records, records)
+		deltas := append(j.deltas[zone], zoneDelta{from: base.serial, to: serial, removed: removed, added: added})
+		if len(deltas) > ixfrJournalDeltas {
+			deltas = deltas[len(deltas)-ixfrJournalDeltas:]
+		}
+		j.deltas[zone] = deltas
+	}
+	for zone := range j.bases {
+		if !zones[zone] {
+			delete(j.bases, zone)
+			delete(j.deltas, zone)
+		}
+	}
+}
+
+// since returns the deltas leading zone from serial to current, or false if the journal does not cover it.
+func (j *zoneJournal) since(zone string, serial, current uint32) ([]zoneDelta, bool) {
+	j.Lock()
+	defer j.Unlock()
+	deltas := j.deltas[strings.ToLower(zone)]
+	for i, delta := range deltas {
+		if delta.from != serial {
+			continue
+		}
+		for k := i; k < len(deltas); k++ {
+			if k > i && deltas[k].from != deltas[k-1].to {
+				return nil, false
+			}
+			if deltas[k].to == current {
+				return deltas[i : k+1], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// diffZone returns the records of oldRecords missing in nextRecords, and those of nextRecords missing in
+// oldRecords.
+func diffZone(oldRecords, nextRecords []record) ([]record, []record) {
+	nextContents := make(map[recordContent]int)
+	for _, record := range nextRecords {
+		nextContents[record.content()]++
+	}
+	oldContents := make(map[recordContent]int)
+	var removed, added []record
+	for _, record := range oldRecords {
+		content := record.content()
+		oldContents[content]++
+		if nextContents[content] > zero {
+			nextContents[content]--
+			continue
+		}
+		removed = append(removed, record)
+	}
+	for _, record := range nextRecords {
+		content := record.content()
+		if oldContents[content] > zero {
+			oldContents[content]--
+			continue
+		}
+		added = append(added, record)
+	}
+	return removed, added
+}
+
+func (r record) content() recordContent {
+	return recordContent{name: strings.ToLower(r.name), qType: r.qType, data: r.data, ttl: r.ttl}
+}
+
+// zoneRecords returns the records of zone zoneID by id, SOA records aside.
+func (s *zoneSnapshot) zoneRecords(zoneID int) []record {
+	records := make([]record, zero, len(s.byZone[zoneID]))
+	for _, record := range s.byZone[zoneID] {
+		if !strings.EqualFold(record.qType, soaQtype) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].id < records[j].id })
+	return records
+}
+
+// serial returns the serial of the SOA record of zone zoneID.
+func (s *zoneSnapshot) serial(zoneID int) (uint32, bool) {
+	for _, record := range s.getRecords(zoneID, zoneSelf, soaQtype) {
+		rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", record.fqdn, record.qType, record.data))
+		if soa, ok := rr.(*dns.SOA); err == nil && ok {
+			return soa.Serial, true
+		}
+	}
+	return zero, false
+}
+
+// serialNewer reports whether serial a is newer than b, RFC 1982.
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > zero
+}
+
+// getZoneRecords returns the records of zone from the snapshot, or from the records table outside of snapshot
+// mode, SOA records aside.
+func (m *Mysql) getZoneRecords(ctx context.Context, zoneID int, zone string) ([]record, error) {
+	if snapshot := m.snapshot.Load(); snapshot != nil {
+		return snapshot.zoneRecords(zoneID), nil
+	}
+	if m.snapshotMode {
+		return nil, errSnapshotNotReady
+	}
+	backend, err := m.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	zoneRecords, err := backend.ListZoneRecords(ctx, zoneID, zone)
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	for _, record := range zoneRecords {
+		if !strings.EqualFold(record.qType, soaQtype) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Transfer implements transfer.Transferer. An AXFR streams the SOA, every online record of the zone and the
+// SOA again. An IXFR from a serial covered by the journal streams the deltas since that serial, RFC 1995,
+// otherwise it falls back to AXFR. The clients allowed to transfer are set by the transfer plugin.
+// A signed zone is refused, it is signed on the fly and its denials of existence are made per query, so a
+// secondary could only serve an unsigned or incomplete copy of it.
+func (m *Mysql) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	zoneID, host, zone, ok := m.findZone(dns.Fqdn(zone))
+	if !ok || host != zoneSelf {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	if m.zoneKeys(zone) != nil {
+		transferCount.With(prometheus.Labels{"type": "axfr", "status": "refused"}).Inc()
+		return nil, fmt.Errorf("failed to transfer zone %s: %w", zone, errSignedTransfer)
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, transferTimeout)
+	defer cancel()
+
+	soa, err := m.getZoneSOA(ctx, zoneID, zone)
+	if err == nil && soa == nil {
+		err = errNoSOA
+	}
+	if err != nil {
+		transferCount.With(prometheus.Labels{"type": "axfr", "status": "fail"}).Inc()
+		return nil, fmt.Errorf("failed to transfer zone %s: %w", zone, err)
+	}
+
+	ch := make(chan []dns.RR)
+	// The zone is up to date, the single SOA tells the transfer plugin so
+	if serial != zero && !serialNewer(soa.Serial, serial) {
+		transferCount.With(prometheus.Labels{"type": "ixfr", "status": "current"}).Inc()
+		go func() {
+			ch <- []dns.RR{soa}
+			close(ch)
+		}()
+		return ch, nil
+	}
+	if serial != zero {
+		if deltas, ok := m.journal.since(zone, serial, soa.Serial); ok {
+			transferCount.With(prometheus.Labels{"type": "ixfr", "status": "success"}).Inc()
+			go m.sendDeltas(ch, soa, deltas)
+			return ch, nil
+		}
+		logger.Debugf("Journal of zone %s does not cover serial %d, fall back to AXFR", zone, serial)
+	}
+
+	records, err := m.getZoneRecords(ctx, zoneID, zone)
+	if err != nil {
+		transferCount.With(prometheus.Labels{"type": "axfr", "status": "fail"}).Inc()
+		return nil, fmt.Errorf("failed to transfer zone %s: %w", zone, err)
+	}
+	transferCount.With(prometheus.Labels{"type": "axfr", "status": "success"}).Inc()
+	go func() {
+		defer close(ch)
+		ch <- []dns.RR{soa}
+		for start := zero; start < len(records); start += transferChunk {
+			end := start + transferChunk
+			if end > len(records) {
+				end = len(records)
+			}
+			if rrs, _ := m.makeAnswers(records[start:end]); len(rrs) > zero {
+				ch <- rrs
+			}
+		}
+		ch <- []dns.RR{soa}
+	}()
+	return ch, nil
+}
+
+// sendDeltas streams an incremental transfer to the current soa, each delta is the SOA of its old serial with
+// the removed records, then the SOA of its new serial with the added records.
+func (m *Mysql) sendDeltas(ch chan<- []dns.RR, soa *dns.SOA, deltas []zoneDelta) {
+	defer close(ch)
+	ch <- []dns.RR{soa}
+	for _, delta := range deltas {
+		from := dns.Copy(soa).(*dns.SOA)
+		from.Serial = delta.from
+		removed, _ := m.makeAnswers(delta.removed)
+		ch <- append([]dns.RR{from}, removed...)
+
+		to := dns.Copy(soa).(*dns.SOA)
+		to.Serial = delta.to
+		added, _ := m.makeAnswers(delta.added)
+		ch <- append([]dns.RR{to}, added...)
+	}
+	ch <- []dns.RR{soa}
+}
@@ -0,0 +1,98 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func transferRRs(t *testing.T, m *Mysql, zone string, serial uint32) []string {
+	t.Helper()
+	ch, err := m.Transfer(zone, serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rrs []string
+	for chunk := range ch {
+		for _, rr := range chunk {
+			if soa, ok := rr.(*dns.SOA); ok {
+				rrs = append(rrs, fmt.Sprintf("SOA %d", soa.Serial))
+				continue
+			}
+			rrs = append(rrs, rr.String())
+		}
+	}
+	return rrs
+}
+
+func TestTransfer(t *testing.T) {
+	m := newTestMysql(t, "snapshot")
+	soa := record{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60}
+	www := record{id: 2, zoneID: 1, name: "www", qType: "A", data: "10.0.0.1", ttl: 60}
+	other := record{id: 3, zoneID: 2, name: "www", qType: "A", data: "10.0.0.3", ttl: 60}
+	snapshot := newZoneSnapshot(map[string]int{"example.org.": 1, "example.net.": 2}, map[int]uint32{})
+	for _, record := range []record{soa, www, other} {
+		snapshot.add(record)
+	}
+	m.swapSnapshot(snapshot)
+
+	want := []string{"SOA 1", "www.example.org.\t60\tIN\tA\t10.0.0.1", "SOA 1"}
+	if got := transferRRs(t, m, "example.org.", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("AXFR got %q, want %q", got, want)
+	}
+
+	soa.data = "ns.example.org. h.example.org. 2 3600 600 86400 300"
+	www.data = "10.0.0.2"
+	snapshot = snapshot.apply([]recordChange{{record: soa, online: true}, {record: www, online: true}})
+	m.swapSnapshot(snapshot)
+	// A change of another zone leaves the journal of the zone alone
+	other.data = "10.0.0.4"
+	m.swapSnapshot(snapshot.apply([]recordChange{{record: other, online: true}}))
+
+	want = []string{"SOA 2", "SOA 1", "www.example.org.\t60\tIN\tA\t10.0.0.1", "SOA 2", "www.example.org.\t60\tIN\tA\t10.0.0.2", "SOA 2"}
+	if got := transferRRs(t, m, "example.org.", 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("IXFR got %q, want %q", got, want)
+	}
+	want = []string{"SOA 2"}
+	if got := transferRRs(t, m, "example.org.", 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("IXFR of the current serial got %q, want %q", got, want)
+	}
+	if _, err := m.Transfer("www.example.org.", 0); err == nil {
+		t.Error("transfer of a name below the apex succeeded")
+	}
+}
+
+// TestTransferBackend checks an AXFR outside of snapshot mode lists the records of the zone only.
+func TestTransferBackend(t *testing.T) {
+	m := newTestMysql(t, fmt.Sprintf("driver sqlite\ndsn file:%s", filepath.Join(t.TempDir(), "dns.db")))
+	m.openEndpoints()
+	t.Cleanup(m.closeEndpoints)
+	backend, _ := m.getBackend()
+	backend.CreateSchema(context.Background())
+	for _, statement := range []string{
+		"INSERT INTO zones(id, zone_name) VALUES(1, 'example.org.'), (2, 'example.net.')",
+		"INSERT INTO records(id, zone_id, hostname, type, data, ttl, online) VALUES" +
+			"(1, 1, '@', 'SOA', 'ns.example.org. h.example.org. 7 3600 600 86400 300', 60, 1)," +
+			"(2, 1, 'www', 'A', '10.0.0.1', 60, 1)," +
+			"(3, 1, 'old', 'A', '10.0.0.2', 60, 0)," +
+			"(4, 2, 'www', 'A', '10.0.0.3', 60, 1)",
+	} {
+		if _, err := backend.(*sqliteBackend).db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zoneMap, zoneTTLs, err := m.queryZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setZoneMap(zoneMap, zoneTTLs)
+
+	want := []string{"SOA 7", "www.example.org.\t60\tIN\tA\t10.0.0.1", "SOA 7"}
+	if got := transferRRs(t, m, "example.org.", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("AXFR got %q, want %q", got, want)
+	}
+}
@@ -1,7 +1,9 @@
 package coredns_mysql_extend
 
 import (
-	"database/sql"
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -11,22 +13,62 @@ import (
 type Mysql struct {
 	*mysqlConfig
 
-	degradeCache map[record]dnsRecordInfo
-	zoneMap      map[string]int
+	degradeCache *recordCache
+	signatures   *signatureCache
+	journal      *zoneJournal
+	serials      *zoneSerials
+	notifier     *notifier
+	zoneMap      atomic.Pointer[map[string]int]
+	zoneTTLs     atomic.Pointer[map[int]uint32]
+	snapshot     atomic.Pointer[zoneSnapshot]
+	// dumpSnapshot is the last full state saved for the cold start outside of snapshot mode
+	dumpSnapshot atomic.Pointer[zoneSnapshot]
 
 	Next plugin.Handler
-	db   *sql.DB
+	// backendLock guards backend, activeEndpoint and the backends of endpointStates, they are switched by rePing
+	// while queries are served
+	backendLock    sync.RWMutex
+	backend        Backend
+	activeEndpoint *endpointState
+	endpointStates []*endpointState
+
+	// ctx is cancelled on shutdown to stop the background loops, workers tracks them
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	// dumpLock serializes the writes of dump_file
+	dumpLock sync.Mutex
 }
 
 type pureRecord map[string][]string
 
 type mysqlConfig struct {
-	dsn          string
+	driver       string
+	endpoints    []endpoint
 	dumpFile     string
 	ttl          uint32
 	zonesTable   string
 	recordsTable string
 
+	minTTL        uint32
+	maxTTL        uint32
+	zoneTTLColumn string
+
+	cnameMaxDepth int
+	cnameExternal string
+	anyResponse   string
+	compress      bool
+
+	// soaSerial is how the serials of the zones are computed, empty keeps the stored ones
+	soaSerial       string
+	serialWriteBack bool
+
+	// notifyTargets are the addresses of the secondaries to notify by lower case zone name
+	notifyTargets map[string][]string
+
+	// dnssecKeys are the keys of the signed zones by lower case zone name
+	dnssecKeys map[string]*zoneKeys
+
 	maxIdleConns    int
 	maxOpenConns    int
 	connMaxIdleTime time.Duration
@@ -37,6 +79,34 @@ type mysqlConfig struct {
 
 	queryZoneSQL   string
 	queryRecordSQL string
+	queryHostSQL   string
+	queryNameSQL   string
+
+	updateRecordSQL string
+
+	snapshotMode      bool
+	queryAllRecordSQL string
+
+	queryZoneRecordSQL string
+
+	changeColumn        string
+	changeTable         string
+	changeHeartbeatTime time.Duration
+	queryChangeSQL      string
+	queryChangeMarkSQL  string
+
+	binlogMode     bool
+	binlogServerID uint32
+
+	queryTimeout time.Duration
+
+	degradeMaxEntries int
+	degradeMaxAge     time.Duration
+
+	dumpInterval    time.Duration
+	dumpGenerations int
+	dumpCompression string
+	coldStartMode   bool
 }
 
 type dnsRecordInfo struct {
@@ -44,6 +114,12 @@ type dnsRecordInfo struct {
 	rrStrings []string
 }
 
+// recordChange is a row pulled by the change tracking refresh, offline or deleted rows are not online.
+type recordChange struct {
+	record record
+	online bool
+}
+
 type zoneRecord struct {
 	id   int
 	name string
@@ -59,3 +135,13 @@ type record struct {
 	fqdn     string
 	ttl      uint32
 }
+
+// setZone fills the zone name and the fully qualified name of a record read from the records table.
+func (r *record) setZone(zone string) {
+	r.zoneName = zone
+	if r.name == zoneSelf {
+		r.fqdn = r.zoneName
+	} else {
+		r.fqdn = r.name + zoneSeparator + r.zoneName
+	}
+}
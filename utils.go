@@ -1,9 +1,11 @@
 package coredns_mysql_extend
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
+	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -20,6 +22,19 @@ func MakeMessage(r *dns.Msg, answers []dns.RR) *dns.Msg {
 }
 
 func (m *Mysql) getDomainInfo(fqdn string) (int, string, string, error) {
+	id, host, zone, ok := m.findZone(fqdn)
+	if ok {
+		logger.Debugf("Query zone %s in zone cache", zone)
+		zoneFindCount.With(prometheus.Labels{"status": "success"}).Inc()
+		return id, host, zone, nil
+	}
+	logger.Warningf("Query zone %s not in zone cache, fqdn: %s", zone, fqdn)
+	zoneFindCount.With(prometheus.Labels{"status": "fail"}).Inc()
+	return id, host, zone, fmt.Errorf("zone %s not exist", fqdn)
+}
+
+// findZone splits fqdn into the longest owned zone and the host part relative to it.
+func (m *Mysql) findZone(fqdn string) (int, string, string, bool) {
 	var (
 		id    int
 		host  string
@@ -37,30 +52,89 @@ func (m *Mysql) getDomainInfo(fqdn string) (int, string, string, error) {
 			host = zoneSelf
 		}
 		if ok {
-			logger.Debugf("Query zone %s in zone cache", zone)
-			zoneFindCount.With(prometheus.Labels{"status": "success"}).Inc()
-			return id, host, zone, nil
+			return id, host, zone, true
 		}
 	}
-	logger.Warningf("Query zone %s not in zone cache, fqdn: %s", zone, fqdn)
-	zoneFindCount.With(prometheus.Labels{"status": "fail"}).Inc()
-	return id, host, zone, fmt.Errorf("zone %s not exist", fqdn)
+	return id, host, zone, false
+}
+
+// makeAuthority returns the NS rrset of zone for the authority section.
+func (m *Mysql) makeAuthority(ctx context.Context, zoneID int, zone string) ([]dns.RR, error) {
+	nsRecords, err := m.getRecords(ctx, zoneID, zoneSelf, zone, nsQtype)
+	if err != nil {
+		return nil, err
+	}
+	ns, _ := m.makeAnswers(nsRecords)
+	return ns, nil
+}
+
+// makeAuthoritativeMessage builds the authoritative reply of answers, with the NS rrset of zone in the authority
+// section and the addresses of the names they refer to in the additional section, as far as state allows.
+func (m *Mysql) makeAuthoritativeMessage(ctx context.Context, state request.Request, answers []dns.RR, zoneID int, zone string) *dns.Msg {
+	msg := MakeMessage(state.Req, answers)
+	msg.Authoritative = true
+
+	// The answer already is the apex NS rrset, no need to repeat it
+	apexNS := false
+	for _, answer := range answers {
+		if answer.Header().Rrtype == dns.TypeNS && strings.EqualFold(answer.Header().Name, zone) {
+			apexNS = true
+			break
+		}
+	}
+	if !apexNS {
+		ns, err := m.makeAuthority(ctx, zoneID, zone)
+		if err != nil {
+			logger.Errorf("Failed to make authority section for zone %s: %s", zone, err)
+		}
+		msg.Ns = ns
+	}
+
+	extra, err := m.makeAdditional(ctx, append(msg.Answer, msg.Ns...))
+	if err != nil {
+		logger.Errorf("Failed to make additional section for zone %s: %s", zone, err)
+	}
+	msg.Extra = extra
+	return msg
 }
 
 func (m *Mysql) getZoneID(zone string) (int, bool) {
-	id, ok := m.zoneMap[zone]
+	zoneMap := m.zoneMap.Load()
+	if zoneMap == nil {
+		return zero, false
+	}
+	id, ok := (*zoneMap)[zone]
 	return id, ok
 }
 
-func (m *Mysql) getBaseZone(fqdn string) string {
-	if strings.Count(fqdn, zoneSeparator) > 1 {
-		return strings.Join(strings.Split(fqdn, zoneSeparator)[1:], zoneSeparator)
+func (m *Mysql) setZoneMap(zoneMap map[string]int, zoneTTLs map[int]uint32) {
+	m.zoneTTLs.Store(&zoneTTLs)
+	m.zoneMap.Store(&zoneMap)
+}
+
+// recordTTL returns the ttl to answer record with, a record without ttl takes the default ttl of its zone,
+// or the ttl directive, then it is clamped between min_ttl and max_ttl.
+func (m *Mysql) recordTTL(record record) uint32 {
+	ttl := record.ttl
+	if ttl == zero {
+		ttl = m.ttl
+		if zoneTTLs := m.zoneTTLs.Load(); zoneTTLs != nil {
+			if zoneTTL, ok := (*zoneTTLs)[record.zoneID]; ok {
+				ttl = zoneTTL
+			}
+		}
+	}
+	if m.minTTL > zero && ttl < m.minTTL {
+		ttl = m.minTTL
 	}
-	return rootZone
+	if m.maxTTL > zero && ttl > m.maxTTL {
+		ttl = m.maxTTL
+	}
+	return ttl
 }
 
 func (m *Mysql) degradeQuery(record record) ([]dns.RR, bool) {
-	dnsRecordInfo, ok := m.degradeCache[record]
+	dnsRecordInfo, ok := m.degradeCache.get(record)
 	if !ok {
 		degradeCacheCount.With(prometheus.Labels{"option": "query", "status": "fail", "fqdn": record.fqdn, "qtype": record.qType}).Inc()
 	} else {
@@ -70,36 +144,88 @@ func (m *Mysql) degradeQuery(record record) ([]dns.RR, bool) {
 }
 
 func (m *Mysql) degradeWrite(record record, dnsRecordInfo dnsRecordInfo) {
-	m.degradeCache[record] = dnsRecordInfo
+	m.degradeCache.set(record, dnsRecordInfo)
 }
 
-func (m *Mysql) getRecords(zoneID int, host, zone, qType string) ([]record, error) {
-	var records []record
+func (m *Mysql) getRecords(ctx context.Context, zoneID int, host, zone, qType string) ([]record, error) {
+	// The snapshot also answers a cold start outside of snapshot mode
+	if snapshot := m.snapshot.Load(); snapshot != nil {
+		return snapshot.getRecords(zoneID, host, qType), nil
+	}
+	if m.snapshotMode {
+		return nil, errSnapshotNotReady
+	}
+	backend, err := m.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout)
+	defer cancel()
+	return backend.LookupRecords(ctx, zoneID, zone, host, qType)
+}
 
-	rows, err := m.db.Query(m.queryRecordSQL, zoneID, host, qType)
+// getHostRecords returns the records of every type owned by host.
+func (m *Mysql) getHostRecords(ctx context.Context, zoneID int, host, zone string) ([]record, error) {
+	if snapshot := m.snapshot.Load(); snapshot != nil {
+		return snapshot.getHostRecords(zoneID, host), nil
+	}
+	if m.snapshotMode {
+		return nil, errSnapshotNotReady
+	}
+	backend, err := m.getBackend()
 	if err != nil {
-		logger.Errorf("Query record error: %s", err)
 		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout)
+	defer cancel()
+	return backend.LookupRecords(ctx, zoneID, zone, host, "")
+}
 
-	for rows.Next() {
-		var record record
-		err := rows.Scan(&record.id, &record.zoneID, &record.name, &record.qType, &record.data, &record.ttl)
+// getZoneSOA returns the SOA record of zone, or nil if the zone has none.
+func (m *Mysql) getZoneSOA(ctx context.Context, zoneID int, zone string) (*dns.SOA, error) {
+	records, err := m.getRecords(ctx, zoneID, zoneSelf, zone, soaQtype)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		rrString := fmt.Sprintf("%s %d IN %s %s", record.fqdn, m.recordTTL(record), record.qType, record.data)
+		rr, err := m.makeAnswer(rrString)
 		if err != nil {
-			queryDBCount.With(prometheus.Labels{"status": "fail"}).Inc()
-			logger.Debugf("Failed to get records for domain %s from database: %s", record.fqdn, err)
-			return nil, err
+			continue
 		}
-		record.zoneName = zone
-		if host == zoneSelf {
-			record.fqdn = record.zoneName
-		} else {
-			record.fqdn = record.name + zoneSeparator + record.zoneName
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa, nil
 		}
-		records = append(records, record)
 	}
-	queryDBCount.With(prometheus.Labels{"status": "success"}).Inc()
-	return records, nil
+	return nil, nil
+}
+
+// makeNegativeMessage builds an NXDOMAIN or NODATA reply for a name inside an owned zone, NODATA if nameExists.
+// It returns a nil message when the zone has no SOA record to put in the authority section.
+func (m *Mysql) makeNegativeMessage(ctx context.Context, r *dns.Msg, zoneID int, zone string, nameExists bool) (*dns.Msg, error) {
+	soa, err := m.getZoneSOA(ctx, zoneID, zone)
+	if err != nil {
+		return nil, err
+	}
+	if soa == nil {
+		logger.Warningf("Zone %s has no SOA record, can not make negative answer", zone)
+		return nil, nil
+	}
+
+	// RFC 2308, the negative TTL is the minimum of the SOA TTL and the SOA minimum field
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+	msg.Ns = []dns.RR{soa}
+	if !nameExists {
+		msg.Rcode = dns.RcodeNameError
+	}
+	negativeAnswerCount.With(prometheus.Labels{"rcode": dns.RcodeToString[msg.Rcode]}).Inc()
+	return msg, nil
 }
 
 func (m *Mysql) makeAnswer(rrString string) (dns.RR, error) {
@@ -110,5 +236,23 @@ func (m *Mysql) makeAnswer(rrString string) (dns.RR, error) {
 	} else {
 		makeAnswerCount.With(prometheus.Labels{"status": "success"}).Inc()
 	}
-	return rr, nil
+	return rr, err
+}
+
+// makeAnswers renders records, skipping the ones that are not valid resource records.
+func (m *Mysql) makeAnswers(records []record) ([]dns.RR, []string) {
+	var (
+		answers   []dns.RR
+		rrStrings []string
+	)
+	for _, record := range records {
+		rrString := fmt.Sprintf("%s %d IN %s %s", record.fqdn, m.recordTTL(record), record.qType, record.data)
+		rrStrings = append(rrStrings, rrString)
+		rr, err := m.makeAnswer(rrString)
+		if err != nil || rr == nil {
+			continue
+		}
+		answers = append(answers, rr)
+	}
+	return answers, rrStrings
 }
@@ -0,0 +1,70 @@
+package coredns_mysql_extend
+
+import (
+	"context"
+	"strings"
+)
+
+// nameExists reports whether host owns records, or is an empty non-terminal whose descendants own records.
+func (m *Mysql) nameExists(ctx context.Context, zoneID int, host, zone string) (bool, error) {
+	if host == zoneSelf {
+		return true, nil
+	}
+	if snapshot := m.snapshot.Load(); snapshot != nil {
+		return snapshot.nameExists(zoneID, host), nil
+	}
+	if m.snapshotMode {
+		return false, errSnapshotNotReady
+	}
+	backend, err := m.getBackend()
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout)
+	defer cancel()
+	return backend.NameExists(ctx, zoneID, host)
+}
+
+// wildcardSource returns the wildcard host of the closest encloser of host, RFC 4592 section 3.3.1.
+func (m *Mysql) wildcardSource(ctx context.Context, zoneID int, host, zone string) (string, error) {
+	labels := strings.Split(host, zoneSeparator)
+	for i := 1; i < len(labels); i++ {
+		encloser := strings.Join(labels[i:], zoneSeparator)
+		exists, err := m.nameExists(ctx, zoneID, encloser, zone)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return wildcard + zoneSeparator + encloser, nil
+		}
+	}
+	// The apex is the closest encloser
+	return wildcard, nil
+}
+
+// wildcardRecords returns the records of qName synthesized from the wildcard of its closest encloser, if qName
+// does not exist. A name owning records of other types or being an empty non-terminal is not synthesized.
+// It also returns whether qName exists, by itself or through the wildcard, so a negative answer is NODATA.
+func (m *Mysql) wildcardRecords(ctx context.Context, qName string, zoneID int, host, zone string) ([]record, bool, error) {
+	exists, err := m.nameExists(ctx, zoneID, host, zone)
+	if err != nil || exists {
+		return nil, exists, err
+	}
+	source, err := m.wildcardSource(ctx, zoneID, host, zone)
+	if err != nil {
+		return nil, false, err
+	}
+	records, err := m.getHostRecords(ctx, zoneID, source, zone)
+	if err != nil || len(records) == zero {
+		return nil, false, err
+	}
+	logger.Debugf("Wildcard %s of zone %s matches %s", source, zone, qName)
+
+	// The records may be shared with the snapshot, rename copies of them
+	synthesized := make([]record, zero, len(records))
+	for _, record := range records {
+		record.fqdn = qName
+		synthesized = append(synthesized, record)
+	}
+	return synthesized, true, nil
+}
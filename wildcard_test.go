@@ -0,0 +1,54 @@
+package coredns_mysql_extend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWildcard(t *testing.T) {
+	m := newSnapshotMysql(t, "", map[string]int{"example.org.": 1},
+		record{id: 1, zoneID: 1, name: zoneSelf, qType: soaQtype, data: "ns.example.org. h.example.org. 1 3600 600 86400 300", ttl: 60},
+		record{id: 2, zoneID: 1, name: "*", qType: "A", data: "10.0.0.9", ttl: 60},
+		record{id: 3, zoneID: 1, name: "www", qType: "A", data: "10.0.0.1", ttl: 60},
+		record{id: 4, zoneID: 1, name: "host.sub", qType: "A", data: "10.0.0.2", ttl: 60},
+		record{id: 5, zoneID: 1, name: "*.deep", qType: "TXT", data: `"deep"`, ttl: 60},
+		record{id: 6, zoneID: 1, name: "*.alias", qType: cnameQtype, data: "www.example.org.", ttl: 60},
+	)
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"any.example.org.", dns.TypeA, dns.RcodeSuccess, []string{"any.example.org.\t60\tIN\tA\t10.0.0.9"}},
+		{"a.b.example.org.", dns.TypeA, dns.RcodeSuccess, []string{"a.b.example.org.\t60\tIN\tA\t10.0.0.9"}},
+		// www exists, the wildcard does not apply to its other types
+		{"www.example.org.", dns.TypeTXT, dns.RcodeSuccess, nil},
+		// sub is an empty non-terminal, and the closest encloser of x.sub has no wildcard
+		{"sub.example.org.", dns.TypeA, dns.RcodeSuccess, nil},
+		{"x.sub.example.org.", dns.TypeA, dns.RcodeNameError, nil},
+		{"a.deep.example.org.", dns.TypeTXT, dns.RcodeSuccess, []string{"a.deep.example.org.\t60\tIN\tTXT\t\"deep\""}},
+		// The wildcard owns other types only
+		{"a.deep.example.org.", dns.TypeA, dns.RcodeSuccess, nil},
+		{"x.alias.example.org.", dns.TypeA, dns.RcodeSuccess, []string{
+			"x.alias.example.org.\t60\tIN\tCNAME\twww.example.org.",
+			"www.example.org.\t60\tIN\tA\t10.0.0.1",
+		}},
+	}
+	for _, tt := range tests {
+		msg := exchange(t, m, tt.name, tt.qType)
+		qType := dns.TypeToString[tt.qType]
+		if msg.Rcode != tt.rcode {
+			t.Errorf("%s %s: rcode %s, want %s", tt.name, qType, dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.rcode])
+		}
+		if got := rrStrings(msg.Answer); !reflect.DeepEqual(got, tt.answer) {
+			t.Errorf("%s %s: answer %q, want %q", tt.name, qType, got, tt.answer)
+		}
+		if len(tt.answer) == zero && (len(msg.Ns) != 1 || msg.Ns[zero].Header().Rrtype != dns.TypeSOA) {
+			t.Errorf("%s %s: authority %v, want the SOA", tt.name, qType, msg.Ns)
+		}
+	}
+}